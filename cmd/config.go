@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Get and set luna settings",
+	Long: `Get and set luna settings.
+
+Settings are read from several files, each overriding the ones before it:
+  system     /etc/luna/config.toml
+  user       ~/.config/luna/config.toml ($XDG_CONFIG_HOME/luna/config.toml)
+  repo       .luna/config.toml, committed with the project
+  local      .git/luna.toml, this clone only
+  workspace  settings of the current workspace, forgotten when it lands
+
+Settings written without a scope go to the local file.
+
+Known settings:
+  trunk.name                the branch workspaces start from and land on (luna)
+  workspace.branch_prefix   prepended to workspace names to get their branch
+  user.name, user.email     the identity of your commits
+  core.editor               the program luna runs to edit messages
+  core.pager                the program log, show and op log page their output with
+  messages.initial_commit   message of the commit luna init creates
+  messages.final_changes    message of the changes left when finishing without steps
+  messages.final_step       description of the changes left when finishing
+  messages.squash_template  file holding the template of squashed commit messages
+  hooks.pre_step            shell command run before a step is committed, a failure
+                            stops it ($LUNA_WORKSPACE)
+  hooks.pre_land            shell command run before a workspace lands, a failure
+                            stops it ($LUNA_WORKSPACE, $LUNA_TRUNK)
+  hooks.post_land           shell command run once a workspace has landed
+                            ($LUNA_WORKSPACE, $LUNA_TRUNK, $LUNA_COMMIT)
+  policy.require_signed     refuse to land a workspace unless its commit is signed
+
+Hooks run from the repository root. Any other section.name key can be stored too,
+for tools built on luna.
+
+Examples:
+  luna config get trunk.name
+  luna config set --user user.email me@example.com
+  luna config set --repo trunk.name main
+  luna config set --workspace messages.final_step "Polish"
+  luna config unset --user core.pager
+  luna config list --show-origin`,
+}
+
+var configGetCmd = &cobra.Command{
+	Use:   "get <key>",
+	Short: "Print the value of a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+		showOrigin, _ := cmd.Flags().GetBool("show-origin")
+
+		configService, err := newConfigService()
+		if err != nil {
+			return err
+		}
+
+		scope, scoped, err := configScope(cmd)
+		if err != nil {
+			return err
+		}
+
+		entries, err := configService.Entries()
+		if err != nil {
+			return err
+		}
+
+		var found *luna.ConfigEntry
+		for i, entry := range entries {
+			if entry.Key == key && (!scoped || entry.Scope == scope) {
+				found = &entries[i]
+			}
+		}
+		if found == nil {
+			return fmt.Errorf("%s is not set", key)
+		}
+
+		if showOrigin {
+			fmt.Printf("%s\t%s\n", configOrigin(*found), found.Value)
+		} else {
+			fmt.Println(found.Value)
+		}
+		return nil
+	},
+}
+
+var configSetCmd = &cobra.Command{
+	Use:   "set <key> <value>",
+	Short: "Write a setting",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key, value := args[0], args[1]
+
+		configService, err := newConfigService()
+		if err != nil {
+			return err
+		}
+
+		scope, _, err := configScope(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := configService.Set(scope, key, value); err != nil {
+			return fmt.Errorf("failed to set %s: %w", key, err)
+		}
+		return nil
+	},
+}
+
+var configUnsetCmd = &cobra.Command{
+	Use:   "unset <key>",
+	Short: "Remove a setting",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		key := args[0]
+
+		configService, err := newConfigService()
+		if err != nil {
+			return err
+		}
+
+		scope, _, err := configScope(cmd)
+		if err != nil {
+			return err
+		}
+
+		if err := configService.Unset(scope, key); err != nil {
+			return fmt.Errorf("failed to unset %s: %w", key, err)
+		}
+		return nil
+	},
+}
+
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List every setting",
+	Long: `List every setting of every file, lowest precedence first. A key listed
+twice takes its last value. With a scope, only the settings of that file are listed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		showOrigin, _ := cmd.Flags().GetBool("show-origin")
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		configService, err := newConfigService()
+		if err != nil {
+			return err
+		}
+
+		scope, scoped, err := configScope(cmd)
+		if err != nil {
+			return err
+		}
+
+		all, err := configService.Entries()
+		if err != nil {
+			return err
+		}
+
+		entries := []luna.ConfigEntry{}
+		for _, entry := range all {
+			if !scoped || entry.Scope == scope {
+				entries = append(entries, entry)
+			}
+		}
+
+		if asJSON {
+			data, err := json.MarshalIndent(entries, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to encode settings: %w", err)
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		for _, entry := range entries {
+			if showOrigin {
+				fmt.Printf("%s\t%s=%s\n", configOrigin(entry), entry.Key, entry.Value)
+			} else {
+				fmt.Printf("%s=%s\n", entry.Key, entry.Value)
+			}
+		}
+		return nil
+	},
+}
+
+func newConfigService() (*luna.ConfigService, error) {
+	wd, err := repositoryRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	gitFactory := git.NewRepositoryFactory()
+	return luna.NewConfigService(wd, gitFactory.NewRepository(wd)), nil
+}
+
+// configScope returns the scope picked with a flag, local and false when there is none.
+func configScope(cmd *cobra.Command) (luna.ConfigScope, bool, error) {
+	var picked []luna.ConfigScope
+	for _, scope := range luna.ConfigScopes {
+		if set, _ := cmd.Flags().GetBool(string(scope)); set {
+			picked = append(picked, scope)
+		}
+	}
+
+	switch len(picked) {
+	case 0:
+		return luna.ConfigScopeLocal, false, nil
+	case 1:
+		return picked[0], true, nil
+	}
+	return "", false, fmt.Errorf("only one of --%s and --%s can be used", picked[0], picked[1])
+}
+
+func configOrigin(entry luna.ConfigEntry) string {
+	if entry.Origin == "" {
+		return string(entry.Scope)
+	}
+	return fmt.Sprintf("%s:%s", entry.Scope, entry.Origin)
+}
+
+func init() {
+	for _, scope := range luna.ConfigScopes {
+		configCmd.PersistentFlags().Bool(string(scope), false, fmt.Sprintf("Use the %s config", scope))
+	}
+
+	configGetCmd.Flags().Bool("show-origin", false, "Show the file the value comes from")
+	configListCmd.Flags().Bool("show-origin", false, "Show the file each value comes from")
+	configListCmd.Flags().Bool("json", false, "Output settings as JSON")
+
+	configCmd.AddCommand(configGetCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUnsetCmd)
+	configCmd.AddCommand(configListCmd)
+	rootCmd.AddCommand(configCmd)
+}
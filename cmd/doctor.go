@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that luna metadata matches the git state",
+	Long: `Check that the luna branch exists, that the current workspace matches HEAD,
+that every workspace has a branch holding all of its steps, and that no branch
+carries work without workspace metadata.
+
+With --fix, each issue that can be repaired is offered for repair; --yes repairs
+them all without asking.
+
+Examples:
+  luna doctor
+  luna doctor --fix
+  luna doctor --fix --yes`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fix, _ := cmd.Flags().GetBool("fix")
+		yes, _ := cmd.Flags().GetBool("yes")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+
+		issues, err := workspaceService.Diagnose(ctx, wd)
+		if err != nil {
+			return fmt.Errorf("failed to diagnose repository: %w", err)
+		}
+
+		if len(issues) == 0 {
+			fmt.Println("No issues found")
+			return nil
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		remaining := 0
+
+		for _, issue := range issues {
+			fmt.Printf("✗ %s\n", issue.Problem)
+
+			if issue.Fix == "" {
+				fmt.Println("  no automatic fix")
+				remaining++
+				continue
+			}
+			if !fix {
+				fmt.Printf("  fix: %s\n", issue.Fix)
+				remaining++
+				continue
+			}
+
+			if !yes {
+				fmt.Printf("  %s? [y/N] ", issue.Fix)
+				answer, _ := reader.ReadString('\n')
+				if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+					remaining++
+					continue
+				}
+			}
+
+			if err := issue.Apply(ctx); err != nil {
+				return fmt.Errorf("failed to fix '%s': %w", issue.Problem, err)
+			}
+			fmt.Printf("  fixed: %s\n", issue.Fix)
+		}
+
+		if remaining > 0 {
+			if !fix {
+				fmt.Println("Run 'luna doctor --fix' to repair")
+			}
+			return fmt.Errorf("%d issue(s) remaining", remaining)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	doctorCmd.Flags().Bool("fix", false, "Repair the issues found")
+	doctorCmd.Flags().BoolP("yes", "y", false, "With --fix, repair without asking")
+	rootCmd.AddCommand(doctorCmd)
+}
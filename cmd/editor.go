@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/okzmo/luna/internal/luna"
+)
+
+// editor returns the editor to run: core.editor from the luna config, then GIT_EDITOR,
+// VISUAL and EDITOR, as git picks it.
+func editor(config *luna.Config) string {
+	for _, candidate := range []string{config.Get(luna.ConfigEditor), os.Getenv("GIT_EDITOR"), os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if candidate != "" {
+			return candidate
+		}
+	}
+	return "vi"
+}
+
+// editText opens text in the editor followed by the help lines, and returns what was saved
+// without the lines starting with #.
+func editText(editorCommand, text string, help []string) (string, error) {
+	f, err := os.CreateTemp("", "LUNA_EDITMSG-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create message file: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	content := strings.TrimRight(text, "\n") + "\n\n"
+	for _, line := range help {
+		content += "# " + line + "\n"
+	}
+	_, err = f.WriteString(content)
+	f.Close()
+	if err != nil {
+		return "", fmt.Errorf("failed to write message file: %w", err)
+	}
+
+	// Through the shell, so editors configured with arguments work
+	command := exec.Command("sh", "-c", editorCommand+` "$@"`, editorCommand, f.Name())
+	command.Stdin = os.Stdin
+	command.Stdout = os.Stdout
+	command.Stderr = os.Stderr
+	if err := command.Run(); err != nil {
+		return "", fmt.Errorf("editor '%s' failed: %w", editorCommand, err)
+	}
+
+	edited, err := os.ReadFile(f.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read message file: %w", err)
+	}
+
+	var lines []string
+	for _, line := range strings.Split(string(edited), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+// isTerminal reports whether luna runs with a terminal on stdin.
+func isTerminal() bool {
+	stat, err := os.Stdin.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log",
+	Short: "Show the luna history grouped by workspace",
+	Long: `Show the history of the luna branch. Each landed workspace is shown with
+its name, description, author, date and the steps it was built from.
+
+With --ws, shows the steps of the current workspace instead.
+
+Examples:
+  luna log
+  luna log -n 5
+  luna log --ws`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		currentWorkspace, _ := cmd.Flags().GetBool("ws")
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		config, err := workspaceService.Config()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		if currentWorkspace {
+			workspace, steps, err := workspaceService.WorkspaceLog(ctx, wd)
+			if err != nil {
+				return fmt.Errorf("failed to read workspace history: %w", err)
+			}
+
+			defer startPager(config)()
+			fmt.Printf("Workspace %s - %s\n", workspace.Name, workspace.Description)
+			fmt.Printf("Created:  %s\n", workspace.CreatedAt.Format("Mon Jan 2 15:04:05 2006 -0700"))
+			printSteps(steps)
+			return nil
+		}
+
+		entries, err := workspaceService.Log(ctx, wd, limit)
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+
+		defer startPager(config)()
+		for i, entry := range entries {
+			if i > 0 {
+				fmt.Println()
+			}
+
+			if entry.Workspace != nil {
+				fmt.Printf("commit %s (workspace %s)\n", shortOrNone(entry.Commit.Hash), entry.Workspace.Workspace.Name)
+			} else {
+				fmt.Printf("commit %s\n", shortOrNone(entry.Commit.Hash))
+			}
+			fmt.Printf("Author: %s <%s>\n", entry.Commit.AuthorName, entry.Commit.AuthorEmail)
+			fmt.Printf("Date:   %s\n", entry.Commit.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+			fmt.Printf("\n    %s\n", entry.Commit.Subject())
+
+			if entry.Workspace != nil {
+				printSteps(entry.Steps)
+			}
+		}
+
+		return nil
+	},
+}
+
+func printSteps(steps []luna.LogStep) {
+	if len(steps) == 0 {
+		fmt.Println("\n    No steps")
+		return
+	}
+
+	fmt.Println("\n    Steps:")
+	for _, step := range steps {
+		if step.Missing {
+			fmt.Printf("      %s %s (commit no longer available)\n", shortOrNone(step.Commit.Hash), step.Description)
+			continue
+		}
+
+		additions, deletions := 0, 0
+		for _, stat := range step.Stats {
+			additions += stat.Additions
+			deletions += stat.Deletions
+		}
+
+		fmt.Printf("      %s %s (%d files, +%d -%d)\n", shortOrNone(step.Commit.Hash), step.Description, len(step.Stats), additions, deletions)
+		for _, stat := range step.Stats {
+			fmt.Printf("          %s | +%d -%d\n", stat.Path, stat.Additions, stat.Deletions)
+		}
+	}
+}
+
+func init() {
+	logCmd.Flags().Bool("ws", false, "Show the steps of the current workspace")
+	logCmd.Flags().IntP("limit", "n", 0, "Limit the number of commits shown")
+	rootCmd.AddCommand(logCmd)
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var metadataCmd = &cobra.Command{
+	Use:   "metadata",
+	Short: "Show where workspace metadata is stored",
+	Long: `Show where workspace metadata is stored.
+
+The 'file' backend keeps it in .git/metadata.json, which never leaves this clone.
+The 'refs' backend keeps each workspace under refs/luna/meta/<workspace>, versioned
+by git and shared with fetch and push; state only meaningful to this clone, like the
+current workspace, stays in .git/metadata.local.json.
+
+The backend is selected by the luna.metadata repository config; use
+'luna metadata migrate' to switch.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		backend, err := workspaceService.MetadataBackend()
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Metadata backend: %s\n", backend)
+		return nil
+	},
+}
+
+var metadataMigrateCmd = &cobra.Command{
+	Use:   "migrate <file|refs>",
+	Short: "Move workspace metadata to another backend",
+	Long: `Move workspace metadata to another backend and select it for this repository.
+
+Examples:
+  luna metadata migrate refs
+  luna metadata migrate file`,
+	Args:      cobra.ExactArgs(1),
+	ValidArgs: []string{luna.MetadataBackendFile, luna.MetadataBackendRefs},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		target := args[0]
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		if err := workspaceService.MigrateMetadataBackend(target); err != nil {
+			return fmt.Errorf("failed to migrate metadata: %w", err)
+		}
+
+		fmt.Printf("Metadata moved to the '%s' backend\n", target)
+		if target == luna.MetadataBackendRefs {
+			fmt.Printf("Share it with: git push <remote> '%s*:%s*'\n", git.MetaRefPrefix, git.MetaRefPrefix)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	metadataCmd.AddCommand(metadataMigrateCmd)
+	rootCmd.AddCommand(metadataCmd)
+}
@@ -1,9 +1,12 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
+	"errors"
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/okzmo/luna/internal/git"
 	"github.com/okzmo/luna/internal/luna"
@@ -18,24 +21,59 @@ var newCmd = &cobra.Command{
 This commits your current work and starts a new step.
 All changes are automatically staged before committing.
 
+To commit only part of the changes, limit the step to some files with --only
+and --exclude, or pick hunks one by one with -p. Changes left out stay in the
+worktree and go into the next step. Globs are relative to the current directory,
+a glob matching a directory covers all of its files, and a glob without a slash
+matches file names at any depth below the current directory.
+
 Examples:
   luna new "Add login form validation"
   luna new "Fix CSS styling issues"
-  luna new "Implement user registration"`,
+  luna new "Implement user registration"
+  luna new --only 'src/auth' "Add session store"
+  luna new --exclude '*.md' "Refactor parser"
+  luna new -p "Split out the bug fix"`,
 	Args: cobra.ExactArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		description := args[0]
+		only, _ := cmd.Flags().GetStringSlice("only")
+		exclude, _ := cmd.Flags().GetStringSlice("exclude")
+		patch, _ := cmd.Flags().GetBool("patch")
 
-		wd, err := os.Getwd()
+		wd, err := repositoryRoot()
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %w", err)
+			return err
 		}
 
 		gitFactory := git.NewRepositoryFactory()
 		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
 
 		ctx := context.Background()
-		if err := workspaceService.CreateStep(ctx, wd, description); err != nil {
+
+		if len(only) == 0 && len(exclude) == 0 && !patch {
+			if err := workspaceService.CreateStep(ctx, wd, description); err != nil {
+				return fmt.Errorf("failed to create step: %w", err)
+			}
+
+			fmt.Printf("Created step: %s\n", description)
+			return nil
+		}
+
+		prefix, err := workingPrefix(wd)
+		if err != nil {
+			return err
+		}
+
+		selection := luna.StepSelection{Only: only, Exclude: exclude, Dir: prefix}
+		if patch {
+			selection.Pick = newHunkPicker().pick
+		}
+
+		if err := workspaceService.CreatePartialStep(ctx, wd, description, selection); err != nil {
+			if errors.Is(err, errStepCancelled) {
+				return err
+			}
 			return fmt.Errorf("failed to create step: %w", err)
 		}
 
@@ -44,6 +82,113 @@ Examples:
 	},
 }
 
+var errStepCancelled = errors.New("step cancelled, nothing was committed")
+
+// hunkPicker asks, like git add -p, which hunks go into the step.
+type hunkPicker struct {
+	input *bufio.Reader
+}
+
+func newHunkPicker() *hunkPicker {
+	return &hunkPicker{input: bufio.NewReader(os.Stdin)}
+}
+
+func (p *hunkPicker) pick(diff *git.FileDiff) (bool, error) {
+	fmt.Printf("%s (%s)\n", diff.Path, diff.Change)
+
+	if diff.Binary {
+		answer, err := p.ask("Commit this binary file [y,n,q]? ")
+		if err != nil {
+			return false, err
+		}
+		return answer == "y", nil
+	}
+
+	var selected []git.Hunk
+	offset := 0
+	for i, h := range diff.Hunks {
+		printHunk(diff, h, offset)
+		offset += len(h.Added) - len(h.Removed)
+
+		answer, err := p.ask(fmt.Sprintf("(%d/%d) Commit this hunk [y,n,a,d,q,?]? ", i+1, len(diff.Hunks)))
+		if err != nil {
+			return false, err
+		}
+
+		switch answer {
+		case "y":
+			selected = append(selected, h)
+		case "a":
+			selected = append(selected, diff.Hunks[i:]...)
+		}
+		if answer == "a" || answer == "d" {
+			break
+		}
+	}
+
+	diff.Hunks = selected
+	return len(selected) > 0, nil
+}
+
+// ask prompts until a valid answer is given; quitting cancels the step.
+func (p *hunkPicker) ask(prompt string) (string, error) {
+	for {
+		fmt.Print(prompt)
+
+		line, err := p.input.ReadString('\n')
+		answer := strings.ToLower(strings.TrimSpace(line))
+		if err != nil && answer == "" {
+			fmt.Println()
+			return "", errStepCancelled
+		}
+
+		switch answer {
+		case "y", "n", "a", "d":
+			return answer, nil
+		case "q":
+			return "", errStepCancelled
+		default:
+			fmt.Println("y - commit this hunk")
+			fmt.Println("n - leave this hunk for a later step")
+			fmt.Println("a - commit this hunk and the rest of the file")
+			fmt.Println("d - leave this hunk and the rest of the file")
+			fmt.Println("q - cancel the step")
+		}
+	}
+}
+
+func printHunk(diff *git.FileDiff, h git.Hunk, offset int) {
+	before, after := diff.Context(h, 3)
+
+	oldStart := h.Start - len(before)
+	oldLines := len(before) + len(h.Removed) + len(after)
+	newLines := len(before) + len(h.Added) + len(after)
+
+	fmt.Printf("@@ -%d,%d +%d,%d @@\n", oldStart+1, oldLines, oldStart+offset+1, newLines)
+	for _, line := range before {
+		fmt.Print(" " + withNewline(line))
+	}
+	for _, line := range h.Removed {
+		fmt.Print("-" + withNewline(line))
+	}
+	for _, line := range h.Added {
+		fmt.Print("+" + withNewline(line))
+	}
+	for _, line := range after {
+		fmt.Print(" " + withNewline(line))
+	}
+}
+
+func withNewline(line string) string {
+	if strings.HasSuffix(line, "\n") {
+		return line
+	}
+	return line + "\n\\ No newline at end of file\n"
+}
+
 func init() {
+	newCmd.Flags().StringSlice("only", nil, "Commit only the files matching these globs")
+	newCmd.Flags().StringSlice("exclude", nil, "Leave out the files matching these globs")
+	newCmd.Flags().BoolP("patch", "p", false, "Pick the hunks to commit interactively")
 	rootCmd.AddCommand(newCmd)
 }
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var opCmd = &cobra.Command{
+	Use:   "op",
+	Short: "Inspect and restore the operation log",
+	Long: `Every luna command that changes the repository is recorded in the operation log,
+along with the branches, hidden references, metadata and uncommitted changes before
+and after it ran. The uncommitted changes are kept under refs/luna/ops/ so git gc
+leaves them alone. At least the last 100 operations are kept, older ones are
+forgotten along with their uncommitted changes.
+
+Examples:
+  luna op log
+  luna op restore 12`,
+}
+
+var opLogCmd = &cobra.Command{
+	Use:   "log",
+	Short: "List recorded operations, most recent first",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		config, err := workspaceService.Config()
+		if err != nil {
+			return err
+		}
+
+		operations, err := workspaceService.Operations()
+		if err != nil {
+			return fmt.Errorf("failed to read operation log: %w", err)
+		}
+
+		if len(operations) == 0 {
+			fmt.Println("No operations recorded")
+			return nil
+		}
+
+		if limit > 0 && len(operations) > limit {
+			operations = operations[:limit]
+		}
+
+		defer startPager(config)()
+
+		for _, operation := range operations {
+			fmt.Printf("%d  %s  %s\n", operation.ID, formatAge(operation.Time), operation.Command)
+			for _, change := range operation.RefChanges() {
+				fmt.Printf("      %s: %s -> %s\n", change.Ref, shortOrNone(change.Before), shortOrNone(change.After))
+			}
+		}
+
+		return nil
+	},
+}
+
+var opRestoreCmd = &cobra.Command{
+	Use:   "restore <id>",
+	Short: "Put the repository back to how it was right after an operation",
+	Long: `Put branches, hidden references and workspace metadata back to how they were
+right after the given operation.
+
+Uncommitted changes are merged onto the restored branch, and files where they conflict
+are left with conflict markers. When the worktree is clean, or with --worktree, the files
+as they were after the operation come back instead; the replaced files stay recoverable
+since the restore is itself recorded.
+
+Examples:
+  luna op restore 12
+  luna op restore 12 --worktree`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restoreWorktree, _ := cmd.Flags().GetBool("worktree")
+
+		id, err := strconv.Atoi(args[0])
+		if err != nil {
+			return fmt.Errorf("invalid operation id '%s'", args[0])
+		}
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+
+		operation, conflicts, err := workspaceService.RestoreOperation(ctx, wd, id, restoreWorktree)
+		if err != nil {
+			return fmt.Errorf("failed to restore operation: %w", err)
+		}
+
+		fmt.Printf("Restored to after operation %d: %s\n", operation.ID, operation.Command)
+		printKeptChangeConflicts(conflicts)
+		return nil
+	},
+}
+
+// printKeptChangeConflicts lists the files where the uncommitted changes kept through an
+// undo or a restore conflict with the restored branch.
+func printKeptChangeConflicts(conflicts []string) {
+	if len(conflicts) == 0 {
+		return
+	}
+
+	fmt.Println("Uncommitted changes conflict with the restored branch in:")
+	for _, path := range conflicts {
+		fmt.Printf("  %s\n", path)
+	}
+	fmt.Println("Fix the conflict markers, or run 'luna undo' to get the changes back as they were")
+}
+
+// shortOrNone abbreviates a commit hash, "(none)" when there is no commit. Hashes already
+// shorter than an abbreviation are shown whole.
+func shortOrNone(hash string) string {
+	if hash == "" {
+		return "(none)"
+	}
+	if len(hash) < 7 {
+		return hash
+	}
+	return hash[:7]
+}
+
+func init() {
+	opLogCmd.Flags().IntP("limit", "n", 0, "Show at most this many operations")
+	opRestoreCmd.Flags().Bool("worktree", false, "Also replace uncommitted changes with the files recorded for the operation")
+	opCmd.AddCommand(opLogCmd)
+	opCmd.AddCommand(opRestoreCmd)
+	rootCmd.AddCommand(opCmd)
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"os"
+	"os/exec"
+
+	"github.com/okzmo/luna/internal/luna"
+)
+
+// pager returns the pager to run: core.pager from the luna config, then GIT_PAGER and
+// PAGER, as git picks it. "cat" turns paging off.
+func pager(config *luna.Config) string {
+	for _, candidate := range []string{config.Get(luna.ConfigPager), os.Getenv("GIT_PAGER"), os.Getenv("PAGER")} {
+		if candidate != "" {
+			return candidate
+		}
+	}
+	return "less"
+}
+
+// startPager sends what luna prints to stdout through the pager when stdout is a terminal.
+// The returned function must be called once everything is printed: it waits for the user
+// to quit the pager.
+func startPager(config *luna.Config) func() {
+	command := pager(config)
+	if command == "cat" || !stdoutIsTerminal() {
+		return func() {}
+	}
+
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return func() {}
+	}
+
+	// Through the shell, so pagers configured with arguments work. Like git, less quits
+	// when the output fits on one screen and keeps colors
+	process := exec.Command("sh", "-c", command)
+	process.Stdin = reader
+	process.Stdout = os.Stdout
+	process.Stderr = os.Stderr
+	process.Env = os.Environ()
+	if _, set := os.LookupEnv("LESS"); !set {
+		process.Env = append(process.Env, "LESS=FRX")
+	}
+	if err := process.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return func() {}
+	}
+	reader.Close()
+
+	stdout := os.Stdout
+	os.Stdout = writer
+	return func() {
+		os.Stdout = stdout
+		writer.Close()
+		process.Wait()
+	}
+}
+
+// stdoutIsTerminal reports whether luna prints to a terminal.
+func stdoutIsTerminal() bool {
+	stat, err := os.Stdout.Stat()
+	return err == nil && stat.Mode()&os.ModeCharDevice != 0
+}
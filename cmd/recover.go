@@ -0,0 +1,186 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Recover from an interrupted luna operation or corrupted metadata",
+	Long: `Recover from a luna operation that was interrupted (crash, Ctrl-C, error)
+while creating a workspace, creating a step or finishing a workspace.
+
+Without flags, shows the interrupted operation. Use --forward to complete it,
+or --back to undo it. Rolling back never throws work away: changes the operation
+already committed come back as uncommitted changes.
+
+If the workspace metadata is corrupted, --from-backup restores a previous version
+(the most recent good one unless a backup number is given) and --rebuild-metadata
+recreates it from the workspace branches. The corrupted file is kept as
+.git/metadata.json.corrupt.
+
+Examples:
+  luna recover
+  luna recover --forward
+  luna recover --back
+  luna recover --from-backup
+  luna recover --from-backup=2
+  luna recover --rebuild-metadata`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		forward, _ := cmd.Flags().GetBool("forward")
+		back, _ := cmd.Flags().GetBool("back")
+		backup, _ := cmd.Flags().GetInt("from-backup")
+		rebuild, _ := cmd.Flags().GetBool("rebuild-metadata")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+
+		switch {
+		case cmd.Flags().Changed("from-backup"):
+			return restoreMetadataBackup(workspaceService, backup)
+		case rebuild:
+			return rebuildMetadata(ctx, workspaceService, wd)
+		case forward:
+			if err := workspaceService.RollForward(ctx, wd); err != nil {
+				return fmt.Errorf("failed to roll forward: %w", err)
+			}
+			fmt.Println("Interrupted operation completed")
+		case back:
+			if err := workspaceService.RollBack(ctx, wd); err != nil {
+				return fmt.Errorf("failed to roll back: %w", err)
+			}
+			fmt.Println("Interrupted operation rolled back")
+		default:
+			recovered := true
+
+			var corruptErr *luna.CorruptMetadataError
+			if err := workspaceService.CheckMetadata(); errors.As(err, &corruptErr) {
+				recovered = false
+				fmt.Printf("Workspace metadata is corrupted: %v\n", corruptErr.Err)
+				if err := printMetadataBackups(workspaceService); err != nil {
+					return err
+				}
+				fmt.Println("Run 'luna recover --from-backup[=N]' or 'luna recover --rebuild-metadata'")
+			} else if err != nil {
+				return err
+			}
+
+			journal, err := workspaceService.PendingJournal()
+			if err != nil {
+				return err
+			}
+			if journal != nil {
+				recovered = false
+				fmt.Printf("Interrupted '%s' of workspace '%s' started %s (stopped at '%s')\n",
+					journal.Operation, journal.Workspace, formatAge(journal.StartedAt), journal.Phase)
+				fmt.Println("Run 'luna recover --forward' to complete it or 'luna recover --back' to undo it")
+			}
+
+			if recovered {
+				fmt.Println("Nothing to recover")
+			}
+		}
+
+		return nil
+	},
+}
+
+func restoreMetadataBackup(workspaceService *luna.WorkspaceService, index int) error {
+	if index == 0 {
+		backups, err := workspaceService.MetadataBackups()
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			return fmt.Errorf("no metadata backup found - use 'luna recover --rebuild-metadata'")
+		}
+		index = backups[0].Index
+	}
+
+	if err := workspaceService.RestoreMetadataBackup(index); err != nil {
+		return fmt.Errorf("failed to restore metadata: %w", err)
+	}
+
+	fmt.Printf("Restored metadata from backup %d\n", index)
+	return nil
+}
+
+func rebuildMetadata(ctx context.Context, workspaceService *luna.WorkspaceService, wd string) error {
+	metadata, err := workspaceService.RebuildMetadata(ctx, wd)
+	if err != nil {
+		return fmt.Errorf("failed to rebuild metadata: %w", err)
+	}
+
+	fmt.Printf("Rebuilt metadata with %d workspace(s)\n", len(metadata.Workspaces))
+	return nil
+}
+
+func printMetadataBackups(workspaceService *luna.WorkspaceService) error {
+	backups, err := workspaceService.MetadataBackups()
+	if err != nil {
+		return err
+	}
+
+	if len(backups) == 0 {
+		fmt.Println("No backup available")
+		return nil
+	}
+
+	fmt.Println("Backups:")
+	for _, backup := range backups {
+		fmt.Printf("  %d  saved %s, %d workspace(s)\n", backup.Index, formatAge(backup.SavedAt), backup.Workspaces)
+	}
+
+	return nil
+}
+
+// offerMetadataRecovery asks how to recover corrupted metadata when luna runs in a terminal,
+// and otherwise returns the corruption error as is.
+func offerMetadataRecovery(ctx context.Context, workspaceService *luna.WorkspaceService, wd string, corruptErr *luna.CorruptMetadataError) error {
+	if stat, err := os.Stdin.Stat(); err != nil || stat.Mode()&os.ModeCharDevice == 0 {
+		return corruptErr
+	}
+
+	fmt.Printf("Workspace metadata is corrupted: %v\n", corruptErr.Err)
+	if err := printMetadataBackups(workspaceService); err != nil {
+		return err
+	}
+	fmt.Print("Restore the latest [b]ackup, [r]ebuild from git, or [q]uit? ")
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "b", "backup":
+		return restoreMetadataBackup(workspaceService, 0)
+	case "r", "rebuild":
+		return rebuildMetadata(ctx, workspaceService, wd)
+	default:
+		return corruptErr
+	}
+}
+
+func init() {
+	recoverCmd.Flags().Bool("forward", false, "Complete the interrupted operation")
+	recoverCmd.Flags().Bool("back", false, "Undo the interrupted operation")
+	recoverCmd.Flags().Int("from-backup", 0, "Restore metadata from a backup, the most recent good one by default")
+	recoverCmd.Flags().Lookup("from-backup").NoOptDefVal = "0"
+	recoverCmd.Flags().Bool("rebuild-metadata", false, "Recreate metadata from the workspace branches")
+	recoverCmd.MarkFlagsMutuallyExclusive("forward", "back", "from-backup", "rebuild-metadata")
+	rootCmd.AddCommand(recoverCmd)
+}
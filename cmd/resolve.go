@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var resolveCmd = &cobra.Command{
+	Use:   "resolve [path...]",
+	Short: "List or mark conflicted files as resolved",
+	Long: `List the files left conflicted by 'luna ws done' or 'luna ws sync', or mark them as resolved.
+
+Without arguments, lists every conflicted file and whether it has been resolved.
+A file can only be marked resolved once its conflict markers are gone. Paths are
+relative to the current directory, as with git.
+
+Examples:
+  luna resolve                 # List conflicted files
+  luna resolve src/main.go     # Mark a file as resolved`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		if len(args) > 0 {
+			paths, err := rootRelativePaths(wd, args)
+			if err != nil {
+				return err
+			}
+			if err := workspaceService.MarkResolved(ctx, wd, paths); err != nil {
+				return fmt.Errorf("failed to resolve: %w", err)
+			}
+		}
+
+		state, err := workspaceService.ConflictState()
+		if err != nil {
+			return err
+		}
+		if state == nil {
+			fmt.Println("No conflicts to resolve")
+			return nil
+		}
+
+		for _, path := range state.ConflictedPaths {
+			if state.IsResolved(path) {
+				fmt.Printf("  resolved    %s\n", path)
+			} else {
+				fmt.Printf("  unresolved  %s\n", path)
+			}
+		}
+
+		if len(state.UnresolvedPaths()) == 0 {
+			command := "done"
+			if state.Operation == luna.OperationSync {
+				command = "sync"
+			}
+			fmt.Printf("All conflicts resolved, run 'luna ws %s --continue'\n", command)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(resolveCmd)
+}
@@ -1,8 +1,15 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
 	"github.com/spf13/cobra"
 )
 
@@ -13,10 +20,216 @@ var rootCmd = &cobra.Command{
 
 Luna VCS provides enhanced safety features and user-friendly workflows
 while using git as the underlying data layer.`,
+	PersistentPreRunE: beforeCommand,
+}
+
+// readOnlyCommands never change the repository, so they are not snapshotted for the operation log.
+var readOnlyCommands = map[string]bool{
+	"status":     true,
+	"log":        true,
+	"show":       true,
+	"list":       true,
+	"get":        true,
+	"verify":     true,
+	"help":       true,
+	"completion": true,
+}
+
+// stateBefore is the repository as it was before the running command, nil for read-only commands.
+var stateBefore *luna.RepoState
+
+// commandLock is the luna lock held by the running command, nil for read-only commands.
+var commandLock *luna.Lock
+
+// commandLine is the running command as typed, used in the operation log and the lock.
+func commandLine() string {
+	return strings.Join(append([]string{"luna"}, os.Args[1:]...), " ")
+}
+
+func beforeCommand(cmd *cobra.Command, args []string) error {
+	if readOnlyCommands[cmd.Name()] || cmd.Name() == "unlock" {
+		return checkInterruptedOperation(cmd, args)
+	}
+
+	wd, err := repositoryRoot()
+	if err != nil {
+		return err
+	}
+
+	gitFactory := git.NewRepositoryFactory()
+	workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+	// There is nothing to lock before luna init creates the repository
+	isRepo, err := gitFactory.NewRepository(wd).IsRepository(wd)
+	if err != nil {
+		return fmt.Errorf("failed to check repository: %w", err)
+	}
+	if isRepo {
+		commandLock, err = workspaceService.Lock(commandLine())
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := checkInterruptedOperation(cmd, args); err != nil {
+		return err
+	}
+
+	if isRepo && !recoveryCommands[cmd.Name()] {
+		var corruptErr *luna.CorruptMetadataError
+		if err := workspaceService.CheckMetadata(); errors.As(err, &corruptErr) {
+			if err := offerMetadataRecovery(context.Background(), workspaceService, wd, corruptErr); err != nil {
+				return err
+			}
+		} else if err != nil {
+			return err
+		}
+	}
+
+	stateBefore, err = workspaceService.SnapshotState(context.Background(), wd)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot repository: %w", err)
+	}
+
+	return nil
+}
+
+// recordOperation adds the command that just ran to the operation log, failed commands
+// included since they may have changed the repository before failing.
+func recordOperation() {
+	if stateBefore == nil {
+		return
+	}
+
+	wd, err := repositoryRoot()
+	if err != nil {
+		return
+	}
+
+	workspaceService := luna.NewWorkspaceService(git.NewRepositoryFactory(), wd)
+
+	if _, err := workspaceService.RecordOperation(context.Background(), wd, commandLine(), stateBefore); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: failed to record operation: %v\n", err)
+	}
+}
+
+// repositoryRoot returns the top of the worktree containing the current directory, so luna
+// works from any subdirectory. Outside a repository it returns the current directory, for
+// luna init and the "not a luna repository" errors.
+func repositoryRoot() (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	root, err := git.FindRoot(wd)
+	if errors.Is(err, git.ErrRepositoryNotFound) {
+		return wd, nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return root, nil
+}
+
+// workingPrefix returns the current directory relative to the repository root, slash
+// separated, "." at the root.
+func workingPrefix(root string) (string, error) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	prefix, err := filepath.Rel(root, wd)
+	if err == nil && (prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator))) {
+		// The root may have been reached through a symlink the current directory was not
+		realRoot, rootErr := filepath.EvalSymlinks(root)
+		realWd, wdErr := filepath.EvalSymlinks(wd)
+		if rootErr == nil && wdErr == nil {
+			prefix, err = filepath.Rel(realRoot, realWd)
+		}
+	}
+	if err != nil || prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator)) {
+		return ".", nil
+	}
+
+	return filepath.ToSlash(prefix), nil
+}
+
+// rootRelativePaths turns paths given relative to the current directory, as git takes
+// them, into slash separated paths relative to the repository root.
+func rootRelativePaths(root string, paths []string) ([]string, error) {
+	prefix, err := workingPrefix(root)
+	if err != nil {
+		return nil, err
+	}
+
+	relative := make([]string, 0, len(paths))
+	for _, p := range paths {
+		joined := filepath.Join(filepath.FromSlash(prefix), p)
+		if filepath.IsAbs(p) {
+			if joined, err = filepath.Rel(root, p); err != nil {
+				return nil, fmt.Errorf("'%s' is outside the repository", p)
+			}
+		}
+
+		joined = filepath.ToSlash(joined)
+		if joined == ".." || strings.HasPrefix(joined, "../") {
+			return nil, fmt.Errorf("'%s' is outside the repository", p)
+		}
+		relative = append(relative, joined)
+	}
+
+	return relative, nil
+}
+
+// recoveryCommands keep working while an interrupted operation waits for recovery.
+var recoveryCommands = map[string]bool{
+	"init":    true,
+	"recover": true,
+	"status":  true,
+	"help":    true,
+}
+
+// checkInterruptedOperation stops every command until an interrupted operation is recovered.
+func checkInterruptedOperation(cmd *cobra.Command, args []string) error {
+	if recoveryCommands[cmd.Name()] {
+		return nil
+	}
+
+	wd, err := repositoryRoot()
+	if err != nil {
+		return err
+	}
+
+	workspaceService := luna.NewWorkspaceService(git.NewRepositoryFactory(), wd)
+
+	journal, err := workspaceService.PendingJournal()
+	if err != nil {
+		return err
+	}
+	if journal != nil {
+		// Without the lock, the journal may belong to another luna process still running
+		if commandLock == nil {
+			if holder, err := workspaceService.LockHolder(); err == nil && holder != nil {
+				return nil
+			}
+		}
+		return fmt.Errorf("an interrupted '%s' of workspace '%s' was found - run 'luna recover --forward' to complete it or 'luna recover --back' to undo it", journal.Operation, journal.Workspace)
+	}
+
+	return nil
 }
 
 func Execute() {
 	err := rootCmd.Execute()
+	recordOperation()
+	if commandLock != nil {
+		if err := commandLock.Unlock(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
 	if err != nil {
 		os.Exit(1)
 	}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var showCmd = &cobra.Command{
+	Use:   "show [commit]",
+	Short: "Show a commit and how it was built step by step",
+	Long: `Show a commit of the trunk branch. When the commit is a landed workspace,
+also shows the workspace it came from and every step it was built from.
+
+Without a commit, shows the tip of the trunk.
+
+Examples:
+  luna show
+  luna show 3f2a9c1`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		config, err := workspaceService.Config()
+		if err != nil {
+			return err
+		}
+
+		revision := config.Trunk
+		if len(args) > 0 {
+			revision = args[0]
+		}
+
+		ctx := context.Background()
+		entry, err := workspaceService.Show(ctx, wd, revision)
+		if err != nil {
+			return fmt.Errorf("failed to show commit: %w", err)
+		}
+
+		defer startPager(config)()
+		fmt.Printf("commit %s\n", entry.Commit.Hash)
+		fmt.Printf("Author: %s <%s>\n", entry.Commit.AuthorName, entry.Commit.AuthorEmail)
+		fmt.Printf("Date:   %s\n\n", entry.Commit.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+		for _, line := range strings.Split(strings.TrimRight(entry.Commit.Message, "\n"), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+
+		if entry.Workspace != nil {
+			workspace := entry.Workspace.Workspace
+			fmt.Printf("\nWorkspace %s - %s\n", workspace.Name, workspace.Description)
+			fmt.Printf("Created:  %s\n", workspace.CreatedAt.Format("Mon Jan 2 15:04:05 2006 -0700"))
+			fmt.Printf("Landed:   %s\n", entry.Workspace.LandedAt.Format("Mon Jan 2 15:04:05 2006 -0700"))
+			printSteps(entry.Steps)
+		}
+
+		fmt.Println()
+		for _, stat := range entry.Stats {
+			fmt.Printf(" %s | +%d -%d\n", stat.Path, stat.Additions, stat.Deletions)
+		}
+		fmt.Printf(" %d files changed\n", len(entry.Stats))
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(showCmd)
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the current workspace and its changes",
+	Long: `Show the current workspace, the step you are working on and the files
+changed since the last step.
+
+Warns when HEAD is not on a workspace branch, when it moved to another branch
+than the workspace luna recorded as current, or when there are uncommitted
+changes directly on the luna branch.
+
+Example:
+  luna status`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		report, err := workspaceService.Status(ctx, wd)
+		if err != nil {
+			return fmt.Errorf("failed to get status: %w", err)
+		}
+
+		if report.Workspace != "" {
+			fmt.Printf("Workspace: %s - %s\n", report.Workspace, report.Description)
+			fmt.Printf("Step:      %s\n", report.CurrentStep)
+		} else {
+			fmt.Println("No active workspace")
+		}
+		if report.Branch != "" {
+			fmt.Printf("Branch:    %s\n", report.Branch)
+		}
+
+		for _, warning := range report.Warnings {
+			fmt.Printf("warning: %s\n", warning)
+		}
+
+		if report.Conflict != nil {
+			fmt.Printf("\n%s of '%s' stopped on conflicts, %d file(s) unresolved - see 'luna resolve'\n",
+				report.Conflict.Operation, report.Conflict.Workspace, len(report.Conflict.UnresolvedPaths()))
+		}
+
+		changes := report.Changes
+		if changes.IsClean() {
+			fmt.Println("\nNothing changed since the last step")
+			return nil
+		}
+
+		if len(changes.Staged) > 0 {
+			fmt.Println("\nStaged:")
+			for _, change := range changes.Staged {
+				fmt.Printf("  %-10s %s\n", change.Change, change.Path)
+			}
+		}
+
+		if len(changes.Unstaged) > 0 {
+			fmt.Println("\nUnstaged:")
+			for _, change := range changes.Unstaged {
+				fmt.Printf("  %-10s %s\n", change.Change, change.Path)
+			}
+		}
+
+		if len(changes.Untracked) > 0 {
+			fmt.Println("\nUntracked:")
+			for _, path := range changes.Untracked {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var undoCmd = &cobra.Command{
+	Use:   "undo",
+	Short: "Undo the last operation",
+	Long: `Put the repository back to how it was before the last recorded operation.
+
+Running undo twice redoes the operation, since the undo is itself recorded.
+Uncommitted changes are kept unless --worktree is given; see 'luna op restore'.
+
+Examples:
+  luna undo
+  luna undo --worktree`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		restoreWorktree, _ := cmd.Flags().GetBool("worktree")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+
+		operation, conflicts, err := workspaceService.Undo(ctx, wd, restoreWorktree)
+		if err != nil {
+			return fmt.Errorf("failed to undo: %w", err)
+		}
+
+		fmt.Printf("Undid operation %d: %s\n", operation.ID, operation.Command)
+		printKeptChangeConflicts(conflicts)
+		return nil
+	},
+}
+
+func init() {
+	undoCmd.Flags().Bool("worktree", false, "Also replace uncommitted changes with the files recorded before the operation")
+	rootCmd.AddCommand(undoCmd)
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "Remove a lock left behind by a luna process that is gone",
+	Long: `Remove the lock luna takes while a command changes the repository.
+
+Locks of processes that died on this machine are broken automatically. Use this
+when the process ran on another machine (shared drive) or luna cannot tell whether
+it is still running. --force removes the lock even if its process looks alive.
+
+Examples:
+  luna unlock
+  luna unlock --force`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		force, _ := cmd.Flags().GetBool("force")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		holder, err := workspaceService.LockHolder()
+		if err != nil {
+			return err
+		}
+		if holder == nil {
+			fmt.Println("Repository is not locked")
+			return nil
+		}
+
+		if err := workspaceService.BreakLock(force); err != nil {
+			return fmt.Errorf("%w (use --force if you are sure it is gone)", err)
+		}
+
+		fmt.Printf("Removed lock held by pid %d (%s)\n", holder.PID, holder.Command)
+		return nil
+	},
+}
+
+func init() {
+	unlockCmd.Flags().Bool("force", false, "Remove the lock even if its process looks alive")
+	rootCmd.AddCommand(unlockCmd)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+	"github.com/okzmo/luna/internal/luna"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [commit...]",
+	Short: "Check the signatures of the commits on the trunk",
+	Long: `Check that the commits of the trunk branch are signed with a trusted key.
+Without commits, checks the whole history of the trunk, newest first.
+
+Signatures are checked the way git checks them: openpgp signatures against the gpg
+keyring, ssh signatures against the file gpg.ssh.allowedSignersFile points to.
+To sign the commits luna creates, set commit.gpgsign, gpg.format and user.signingkey
+in your git config.
+
+Fails if any commit checked is unsigned or not signed by a trusted key.
+
+Examples:
+  luna verify
+  luna verify -n 10
+  luna verify 3f2a9c1`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		limit, _ := cmd.Flags().GetInt("limit")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		entries, err := workspaceService.Verify(ctx, wd, args, limit)
+		if err != nil {
+			return err
+		}
+
+		bad := 0
+		for _, entry := range entries {
+			check := entry.Check
+			switch {
+			case check.Good:
+				fmt.Printf("✓ %s good %s signature from %s - %s\n", shortOrNone(entry.Commit.Hash), check.Format, check.Signer, entry.Commit.Subject())
+			case check.Format == "":
+				bad++
+				fmt.Printf("✗ %s %s - %s\n", shortOrNone(entry.Commit.Hash), check.Reason, entry.Commit.Subject())
+			default:
+				bad++
+				signer := ""
+				if check.Signer != "" {
+					signer = " from " + check.Signer
+				}
+				fmt.Printf("✗ %s %s signature%s: %s - %s\n", shortOrNone(entry.Commit.Hash), check.Format, signer, check.Reason, entry.Commit.Subject())
+			}
+		}
+
+		if bad > 0 {
+			return fmt.Errorf("%d of %d commits are not properly signed", bad, len(entries))
+		}
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().IntP("limit", "n", 0, "Limit the number of trunk commits checked")
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"text/tabwriter"
 
 	"github.com/okzmo/luna/internal/git"
 	"github.com/okzmo/luna/internal/luna"
@@ -27,9 +30,9 @@ For backward compatibility, you can also use:
 			name := args[0]
 			description := args[1]
 
-			wd, err := os.Getwd()
+			wd, err := repositoryRoot()
 			if err != nil {
-				return fmt.Errorf("failed to get current directory: %w", err)
+				return err
 			}
 
 			gitFactory := git.NewRepositoryFactory()
@@ -49,27 +52,55 @@ For backward compatibility, you can also use:
 }
 
 var wsCreateCmd = &cobra.Command{
-	Use:   "create <name> <description>",
+	Use:   "create <name> <description> [dir]",
 	Short: "Create a new workspace",
 	Long: `Create a new workspace to isolate your work.
 
+With --worktree, the workspace gets its own git worktree instead of being
+checked out here, so several workspaces can be worked on at the same time.
+The worktree goes in dir, or next to the repository as <repo>-<name>.
+
 Examples:
   luna ws create feature-auth "Add user authentication"  
-  luna ws create bugfix-login "Fix login validation issue"`,
-	Args: cobra.ExactArgs(2),
+  luna ws create bugfix-login "Fix login validation issue"
+  luna ws create --worktree hotfix "Fix crash on startup"
+  luna ws create --worktree hotfix "Fix crash on startup" ../hotfix`,
+	Args: cobra.RangeArgs(2, 3),
 	RunE: func(cmd *cobra.Command, args []string) error {
 		name := args[0]
 		description := args[1]
+		worktree, _ := cmd.Flags().GetBool("worktree")
+
+		if len(args) == 3 && !worktree {
+			return fmt.Errorf("a directory can only be given with --worktree")
+		}
 
-		wd, err := os.Getwd()
+		wd, err := repositoryRoot()
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %w", err)
+			return err
 		}
 
 		gitFactory := git.NewRepositoryFactory()
 		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
 
 		ctx := context.Background()
+
+		if worktree {
+			var dir string
+			if len(args) == 3 {
+				dir = args[2]
+			}
+
+			path, err := workspaceService.CreateWorkspaceInWorktree(ctx, wd, name, description, dir)
+			if err != nil {
+				return fmt.Errorf("failed to create workspace: %w", err)
+			}
+
+			fmt.Printf("Created workspace '%s' - %s\n", name, description)
+			fmt.Printf("Worktree ready, run: cd %s\n", path)
+			return nil
+		}
+
 		if err := workspaceService.CreateWorkspace(ctx, wd, name, description); err != nil {
 			return fmt.Errorf("failed to create workspace: %w", err)
 		}
@@ -86,35 +117,451 @@ var wsDoneCmd = &cobra.Command{
 
 This will:
 - Squash all commits in the workspace into one commit
-- Use the workspace description as the commit message
+- Write its message from the workspace description and steps
 - Rebase the squashed commit onto the luna branch
 - Delete the workspace branch
 - Switch back to the luna branch
 
-Example:
-  luna ws done`,
+A branch can only be checked out in one worktree: when the luna branch already is
+in another one, the worktree the workspace was finished in is left detached on the
+landed commit instead, ready to be removed with 'git worktree remove'.
+
+The message is the workspace description, the list of its steps, and the
+trailers Luna-Workspace: and Co-authored-by: for every other author of a step. With --signoff, a Signed-off-by: trailer is added for you.
+Point messages.squash_template at a Go text/template file to write it your way
+(see 'luna config'). When run in a terminal, the message opens in your editor
+before landing; an empty message cancels the finish.
+
+If the luna branch changed the same lines as the workspace, the finish stops
+with conflict markers written into the conflicted files. Fix them, mark them
+with 'luna resolve <path>' and run 'luna ws done --continue', or run
+'luna ws done --abort' to get the workspace back as it was.
+
+Examples:
+  luna ws done
+  luna ws done --signoff
+  luna ws done -m "Add user authentication"
+  luna ws done --continue
+  luna ws done --abort`,
 	Args: cobra.NoArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		wd, err := os.Getwd()
+		continueFinish, _ := cmd.Flags().GetBool("continue")
+		abortFinish, _ := cmd.Flags().GetBool("abort")
+		message, _ := cmd.Flags().GetString("message")
+		signoff, _ := cmd.Flags().GetBool("signoff")
+		edit, _ := cmd.Flags().GetBool("edit")
+		noEdit, _ := cmd.Flags().GetBool("no-edit")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		config, err := workspaceService.Config()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+
+		switch {
+		case abortFinish:
+			if err := workspaceService.AbortFinish(ctx, wd); err != nil {
+				return fmt.Errorf("failed to abort workspace finish: %w", err)
+			}
+
+			fmt.Println("Workspace finish aborted, workspace restored")
+			return nil
+		case continueFinish:
+			if err := workspaceService.ContinueFinish(ctx, wd); err != nil {
+				return fmt.Errorf("failed to continue workspace finish: %w", err)
+			}
+		default:
+			options := luna.FinishOptions{Message: message, Signoff: signoff}
+			if edit || (message == "" && !noEdit && isTerminal()) {
+				options.Edit = func(message string) (string, error) {
+					return editText(editor(config), message, []string{
+						fmt.Sprintf("Message of the squashed commit landing on %s.", config.Trunk),
+						"Lines starting with '#' are ignored, an empty message cancels the finish.",
+					})
+				}
+			}
+
+			if err := workspaceService.FinishWorkspace(ctx, wd, options); err != nil {
+				var conflictErr *git.ConflictError
+				if errors.As(err, &conflictErr) {
+					fmt.Printf("Conflicts while merging onto %s:\n", config.Trunk)
+					for _, path := range conflictErr.Paths {
+						fmt.Printf("  %s\n", path)
+					}
+					fmt.Println("Fix them, run 'luna resolve <path>' and then 'luna ws done --continue'")
+					return fmt.Errorf("workspace has conflicts")
+				}
+				return fmt.Errorf("failed to finish workspace: %w", err)
+			}
+		}
+
+		fmt.Printf("Workspace completed and merged to %s branch\n", config.Trunk)
+		if _, err := gitFactory.NewRepository(wd).GetCurrentBranch(ctx); err != nil {
+			fmt.Printf("%s is checked out in another worktree, this one is left detached - remove it with 'git worktree remove %s'\n", config.Trunk, wd)
+		}
+		return nil
+	},
+}
+
+var wsOpenCmd = &cobra.Command{
+	Use:   "open <name> [dir]",
+	Short: "Open a workspace in its own worktree",
+	Long: `Check out an existing workspace in its own git worktree, so it can be worked
+on while this worktree stays where it is. Uncommitted changes parked when
+switching away from the workspace come along.
+
+The worktree goes in dir, or next to the repository as <repo>-<name>. If the
+workspace is already open in a worktree, its path is shown.
+
+Examples:
+  luna ws open feature-auth
+  luna ws open feature-auth ../auth`,
+	Args: cobra.RangeArgs(1, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		var dir string
+		if len(args) == 2 {
+			dir = args[1]
+		}
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		path, err := workspaceService.OpenWorkspace(ctx, wd, name, dir)
+		if err != nil {
+			return fmt.Errorf("failed to open workspace: %w", err)
+		}
+
+		fmt.Printf("Workspace '%s' is open, run: cd %s\n", name, path)
+		return nil
+	},
+}
+
+var wsSyncCmd = &cobra.Command{
+	Use:   "sync [name]",
+	Short: "Rebase workspaces onto the latest luna branch",
+	Long: `Replay the steps of a workspace on top of the current luna branch.
+
+Without a name, syncs the current workspace. Each step commit is rewritten
+and the workspace metadata is updated to point at the new commits.
+
+If a step does not apply cleanly, the sync stops with conflict markers in the
+conflicted files. Fix them, mark them with 'luna resolve <path>' and run
+'luna ws sync --continue', or run 'luna ws sync --abort'.
+
+Examples:
+  luna ws sync                 # Sync the current workspace
+  luna ws sync feature-auth    # Sync a specific workspace
+  luna ws sync --all           # Sync every workspace`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		all, _ := cmd.Flags().GetBool("all")
+		continueSync, _ := cmd.Flags().GetBool("continue")
+		abortSync, _ := cmd.Flags().GetBool("abort")
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		wd, err := repositoryRoot()
 		if err != nil {
-			return fmt.Errorf("failed to get current directory: %w", err)
+			return err
 		}
 
 		gitFactory := git.NewRepositoryFactory()
 		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
 
+		config, err := workspaceService.Config()
+		if err != nil {
+			return err
+		}
+
 		ctx := context.Background()
-		if err := workspaceService.FinishWorkspace(ctx, wd); err != nil {
-			return fmt.Errorf("failed to finish workspace: %w", err)
+
+		switch {
+		case abortSync:
+			if err := workspaceService.AbortSync(ctx, wd); err != nil {
+				return fmt.Errorf("failed to abort sync: %w", err)
+			}
+			fmt.Println("Sync aborted, workspace restored")
+		case continueSync:
+			if err := workspaceService.ContinueSync(ctx, wd); err != nil {
+				return syncError(err)
+			}
+			fmt.Printf("Workspace synced with %s branch\n", config.Trunk)
+		case all:
+			synced, err := workspaceService.SyncAllWorkspaces(ctx, wd)
+			for _, ws := range synced {
+				fmt.Printf("Synced workspace '%s'\n", ws)
+			}
+			if err != nil {
+				return syncError(err)
+			}
+		default:
+			if err := workspaceService.SyncWorkspace(ctx, wd, name); err != nil {
+				return syncError(err)
+			}
+			fmt.Printf("Workspace synced with %s branch\n", config.Trunk)
 		}
 
-		fmt.Println("Workspace completed and merged to luna branch")
 		return nil
 	},
 }
 
+var wsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List all workspaces",
+	Long: `List every workspace with its description, number of steps, age and how
+far its branch is ahead of and behind the luna branch.
+
+The current workspace is marked with '*'. Workspaces whose git branch is missing,
+and branches carrying work without workspace metadata, are flagged.
+
+Examples:
+  luna ws list
+  luna ws list --json`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, _ := cmd.Flags().GetBool("json")
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		workspaces, err := workspaceService.ListWorkspaces(ctx, wd)
+		if err != nil {
+			return fmt.Errorf("failed to list workspaces: %w", err)
+		}
+
+		if asJSON {
+			if workspaces == nil {
+				workspaces = []luna.WorkspaceStatus{}
+			}
+			data, err := json.MarshalIndent(workspaces, "", "  ")
+			if err != nil {
+				return fmt.Errorf("failed to marshal workspaces: %w", err)
+			}
+			fmt.Println(string(data))
+			return nil
+		}
+
+		if len(workspaces) == 0 {
+			fmt.Println("No workspaces - create one with 'luna ws <name> <description>'")
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, ws := range workspaces {
+			marker := " "
+			if ws.Current {
+				marker = "*"
+			}
+
+			state := fmt.Sprintf("+%d/-%d", ws.Ahead, ws.Behind)
+			switch {
+			case !ws.BranchExists:
+				state = "branch missing"
+			case !ws.MetadataExists:
+				state += " (metadata missing)"
+			}
+
+			if ws.Worktree != "" && !ws.Current {
+				state += " (open in " + ws.Worktree + ")"
+			}
+
+			fmt.Fprintf(w, "%s %s\t%s\t%d steps\t%s\t%s\n", marker, ws.Name, ws.Description, ws.Steps, formatAge(ws.CreatedAt), state)
+		}
+		return w.Flush()
+	},
+}
+
+var wsSwitchCmd = &cobra.Command{
+	Use:   "switch <name>",
+	Short: "Switch to another workspace",
+	Long: `Switch to another workspace, keeping your uncommitted work safe.
+
+Uncommitted changes of the current workspace are parked in a hidden snapshot
+(not a step) and brought back the next time you switch to it. Use 'luna' as the
+name to go back to the luna branch.
+
+Examples:
+  luna ws switch feature-auth
+  luna ws switch luna`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		result, err := workspaceService.SwitchWorkspace(ctx, wd, name)
+		if err != nil {
+			return fmt.Errorf("failed to switch workspace: %w", err)
+		}
+
+		if result.SavedWIP {
+			fmt.Println("Saved uncommitted changes")
+		}
+		fmt.Printf("Switched to '%s'\n", name)
+		if result.RestoredWIP {
+			fmt.Println("Restored uncommitted changes")
+		}
+		if len(result.Conflicts) > 0 {
+			fmt.Println("Restored changes conflict with newer steps in:")
+			for _, path := range result.Conflicts {
+				fmt.Printf("  %s\n", path)
+			}
+		}
+		return nil
+	},
+}
+
+var wsDropCmd = &cobra.Command{
+	Use:   "drop [name]",
+	Short: "Abandon a workspace without landing it",
+	Long: `Abandon a workspace: delete its branch and metadata and go back to the luna branch.
+
+Without a name, drops the current workspace. Nothing is lost right away: the
+workspace tip, including uncommitted changes, is kept under refs/luna/dropped/<name>
+for the retention period and can be brought back with 'luna ws undrop <name>'.
+When a workspace of the same name was dropped before and is still kept, the new
+one is kept as <name>@2, <name>@3 and so on.
+
+Examples:
+  luna ws drop
+  luna ws drop feature-auth
+  luna ws drop spike --retention 168h`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		retention, _ := cmd.Flags().GetDuration("retention")
+
+		var name string
+		if len(args) > 0 {
+			name = args[0]
+		}
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		key, err := workspaceService.DropWorkspace(ctx, wd, name, retention)
+		if err != nil {
+			return fmt.Errorf("failed to drop workspace: %w", err)
+		}
+
+		fmt.Printf("Workspace dropped, recover it with 'luna ws undrop %s'\n", key)
+		return nil
+	},
+}
+
+var wsUndropCmd = &cobra.Command{
+	Use:   "undrop <name>",
+	Short: "Recover a dropped workspace",
+	Long: `Recover a workspace abandoned with 'luna ws drop', as long as its retention
+period is not over. Switch to it afterwards with 'luna ws switch <name>'.
+
+A workspace dropped while another of the same name was still kept is recovered
+with the name 'luna ws drop' gave it, such as feature-auth@2.
+
+Examples:
+  luna ws undrop feature-auth
+  luna ws undrop feature-auth@2`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+
+		wd, err := repositoryRoot()
+		if err != nil {
+			return err
+		}
+
+		gitFactory := git.NewRepositoryFactory()
+		workspaceService := luna.NewWorkspaceService(gitFactory, wd)
+
+		ctx := context.Background()
+		recovered, err := workspaceService.UndropWorkspace(ctx, wd, name)
+		if err != nil {
+			return fmt.Errorf("failed to recover workspace: %w", err)
+		}
+
+		fmt.Printf("Recovered workspace '%s'\n", recovered)
+		return nil
+	},
+}
+
+func syncError(err error) error {
+	var conflictErr *git.ConflictError
+	if errors.As(err, &conflictErr) {
+		fmt.Printf("Conflicts while replaying step %s:\n", shortOrNone(conflictErr.Commit))
+		for _, path := range conflictErr.Paths {
+			fmt.Printf("  %s\n", path)
+		}
+		fmt.Println("Fix them, run 'luna resolve <path>' and then 'luna ws sync --continue'")
+		return fmt.Errorf("workspace has conflicts")
+	}
+	return fmt.Errorf("failed to sync workspace: %w", err)
+}
+
 func init() {
+	wsDoneCmd.Flags().Bool("continue", false, "Land the workspace after resolving conflicts")
+	wsDoneCmd.Flags().Bool("abort", false, "Abandon the conflicted finish and restore the workspace")
+	wsDoneCmd.Flags().StringP("message", "m", "", "Land with this message instead of the one from the squash template")
+	wsDoneCmd.Flags().BoolP("signoff", "s", false, "Add a Signed-off-by trailer to the message")
+	wsDoneCmd.Flags().Bool("edit", false, "Review the message in your editor even outside a terminal")
+	wsDoneCmd.Flags().Bool("no-edit", false, "Land without reviewing the message")
+	wsDoneCmd.MarkFlagsMutuallyExclusive("continue", "abort")
+	wsDoneCmd.MarkFlagsMutuallyExclusive("edit", "no-edit")
+
+	wsSyncCmd.Flags().Bool("all", false, "Sync every workspace")
+	wsSyncCmd.Flags().Bool("continue", false, "Resume the sync after resolving conflicts")
+	wsSyncCmd.Flags().Bool("abort", false, "Abandon the conflicted sync and restore the workspace")
+	wsSyncCmd.MarkFlagsMutuallyExclusive("all", "continue", "abort")
+
+	wsCreateCmd.Flags().Bool("worktree", false, "Check out the workspace in its own worktree")
+
+	wsListCmd.Flags().Bool("json", false, "Output workspaces as JSON")
+	wsDropCmd.Flags().Duration("retention", luna.DefaultDropRetention, "How long the dropped workspace stays recoverable")
+
 	wsCmd.AddCommand(wsCreateCmd)
+	wsCmd.AddCommand(wsOpenCmd)
 	wsCmd.AddCommand(wsDoneCmd)
+	wsCmd.AddCommand(wsSyncCmd)
+	wsCmd.AddCommand(wsListCmd)
+	wsCmd.AddCommand(wsSwitchCmd)
+	wsCmd.AddCommand(wsDropCmd)
+	wsCmd.AddCommand(wsUndropCmd)
 	rootCmd.AddCommand(wsCmd)
 }
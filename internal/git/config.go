@@ -0,0 +1,109 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// splitConfigKey splits "section.key" or "section.subsection.key" into its parts.
+func splitConfigKey(key string) (section, subsection, option string, err error) {
+	first := strings.Index(key, ".")
+	last := strings.LastIndex(key, ".")
+	if first <= 0 || last == len(key)-1 {
+		return "", "", "", fmt.Errorf("invalid config key '%s'", key)
+	}
+
+	section = key[:first]
+	option = key[last+1:]
+	if first != last {
+		subsection = key[first+1 : last]
+	}
+
+	return section, subsection, option, nil
+}
+
+func (r *gitRepository) GetConfig(ctx context.Context, key string) (string, error) {
+	section, subsection, option, err := splitConfigKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return "", fmt.Errorf("failed to read repository config: %w", err)
+	}
+
+	if !cfg.Raw.HasSection(section) {
+		return "", nil
+	}
+	if subsection == "" {
+		return cfg.Raw.Section(section).Option(option), nil
+	}
+	if !cfg.Raw.Section(section).HasSubsection(subsection) {
+		return "", nil
+	}
+	return cfg.Raw.Section(section).Subsection(subsection).Option(option), nil
+}
+
+func (r *gitRepository) SetConfig(ctx context.Context, key, value string) error {
+	section, subsection, option, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repository config: %w", err)
+	}
+
+	cfg.Raw.SetOption(section, subsection, option, value)
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	return nil
+}
+
+func (r *gitRepository) UnsetConfig(ctx context.Context, key string) error {
+	section, subsection, option, err := splitConfigKey(key)
+	if err != nil {
+		return err
+	}
+
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	cfg, err := repo.Config()
+	if err != nil {
+		return fmt.Errorf("failed to read repository config: %w", err)
+	}
+
+	if !cfg.Raw.HasSection(section) {
+		return nil
+	}
+	if subsection == "" {
+		cfg.Raw.Section(section).RemoveOption(option)
+	} else if cfg.Raw.Section(section).HasSubsection(subsection) {
+		cfg.Raw.Section(section).Subsection(subsection).RemoveOption(option)
+	}
+
+	if err := repo.SetConfig(cfg); err != nil {
+		return fmt.Errorf("failed to write repository config: %w", err)
+	}
+
+	return nil
+}
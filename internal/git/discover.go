@@ -0,0 +1,151 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrRepositoryNotFound is returned when no repository contains the directory searched from.
+var ErrRepositoryNotFound = errors.New("not inside a luna repository")
+
+// FindRoot returns the top of the worktree containing dir, the way git finds it: GIT_WORK_TREE
+// when set, the current directory when only GIT_DIR is set, and otherwise the closest of dir
+// and its parents holding a .git directory or file, without going up into any directory of
+// GIT_CEILING_DIRECTORIES.
+func FindRoot(dir string) (string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	if workTree := os.Getenv("GIT_WORK_TREE"); workTree != "" {
+		return absFrom(cwd, workTree), nil
+	}
+	if os.Getenv("GIT_DIR") != "" {
+		return cwd, nil
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	found, err := findDotGit(dir)
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Dir(found), nil
+}
+
+// findDotGit walks up from dir to the first .git directory or file.
+func findDotGit(dir string) (string, error) {
+	ceilings := ceilingDirectories()
+
+	for {
+		dotGit := filepath.Join(dir, ".git")
+		if _, err := os.Stat(dotGit); err == nil {
+			return dotGit, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir || ceilings[parent] {
+			return "", ErrRepositoryNotFound
+		}
+		dir = parent
+	}
+}
+
+// ceilingDirectories parses GIT_CEILING_DIRECTORIES, a list of absolute paths the search
+// for a repository does not go up into.
+func ceilingDirectories() map[string]bool {
+	ceilings := make(map[string]bool)
+
+	for _, dir := range filepath.SplitList(os.Getenv("GIT_CEILING_DIRECTORIES")) {
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		ceilings[filepath.Clean(dir)] = true
+	}
+
+	return ceilings
+}
+
+// envDotGit returns the git directory designated by GIT_DIR, or found from the current
+// directory when only GIT_WORK_TREE is set, if path is the worktree those variables apply to.
+func envDotGit(path string) (string, bool) {
+	gitDir, workTree := os.Getenv("GIT_DIR"), os.Getenv("GIT_WORK_TREE")
+	if gitDir == "" && workTree == "" {
+		return "", false
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", false
+	}
+
+	root := cwd
+	if workTree != "" {
+		root = absFrom(cwd, workTree)
+	}
+	if abs, err := filepath.Abs(path); err != nil || abs != root {
+		return "", false
+	}
+
+	if gitDir != "" {
+		return absFrom(cwd, gitDir), true
+	}
+
+	found, err := findDotGit(cwd)
+	if err != nil {
+		return "", false
+	}
+	return found, true
+}
+
+func absFrom(base, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(base, path)
+}
+
+// ResolveGitDirs returns the git directory of the worktree at path and the common directory
+// shared by all worktrees of its repository. They are the same outside linked worktrees,
+// whose .git is a file pointing to their own directory under the common .git/worktrees.
+func ResolveGitDirs(path string) (gitDir, commonDir string, err error) {
+	dotGit, fromEnv := envDotGit(path)
+	if !fromEnv {
+		dotGit = filepath.Join(path, ".git")
+	}
+
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", "", err
+	}
+
+	gitDir = dotGit
+	if !info.IsDir() {
+		data, err := os.ReadFile(dotGit)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read .git file: %w", err)
+		}
+
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir:") {
+			return "", "", fmt.Errorf("invalid .git file %s", dotGit)
+		}
+
+		gitDir = absFrom(filepath.Dir(dotGit), strings.TrimSpace(strings.TrimPrefix(line, "gitdir:")))
+	}
+
+	commonDir = gitDir
+	if data, err := os.ReadFile(filepath.Join(gitDir, "commondir")); err == nil {
+		commonDir = absFrom(gitDir, strings.TrimSpace(string(data)))
+	}
+
+	return gitDir, commonDir, nil
+}
@@ -0,0 +1,229 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/go-git/go-billy/v6/util"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// FileDiff is how a file of the worktree differs from HEAD.
+type FileDiff struct {
+	Path string
+	// Change is "added", "modified" or "deleted".
+	Change string
+	// Binary files have no hunks and are committed whole.
+	Binary bool
+	Hunks  []Hunk
+
+	old []string
+}
+
+// Hunk replaces the lines [Start, End) of the HEAD version of a file, counted from 0,
+// with Added.
+type Hunk struct {
+	Start   int
+	End     int
+	Removed []string
+	Added   []string
+}
+
+// Context returns up to n unchanged lines of the HEAD version before and after a hunk.
+func (d *FileDiff) Context(h Hunk, n int) ([]string, []string) {
+	return d.old[max(0, h.Start-n):h.Start], d.old[h.End:min(len(d.old), h.End+n)]
+}
+
+// WorktreeDiff returns the changes of the worktree against HEAD, untracked files included,
+// sorted by path.
+func (r *gitRepository) WorktreeDiff(ctx context.Context) ([]FileDiff, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	headTree, err := headTree(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	var diffs []FileDiff
+	for _, path := range paths {
+		var old []byte
+		inHead := false
+		if headTree != nil {
+			if file, err := headTree.File(path); err == nil {
+				contents, err := file.Contents()
+				if err != nil {
+					return nil, fmt.Errorf("failed to read %s from HEAD: %w", path, err)
+				}
+				old, inHead = []byte(contents), true
+			}
+		}
+
+		current, err := util.ReadFile(worktree.Filesystem, path)
+		inWorktree := err == nil
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+
+		diff := FileDiff{Path: path, Change: "modified"}
+		switch {
+		case !inHead && !inWorktree:
+			continue
+		case !inHead:
+			diff.Change = "added"
+		case !inWorktree:
+			diff.Change = "deleted"
+		case string(old) == string(current):
+			// Only the index differs from HEAD
+			continue
+		}
+
+		if isBinary(old) || isBinary(current) {
+			diff.Binary = true
+			diffs = append(diffs, diff)
+			continue
+		}
+
+		diff.old = splitLines(string(old))
+		for _, h := range diffHunks(diff.old, splitLines(string(current))) {
+			diff.Hunks = append(diff.Hunks, Hunk{
+				Start:   h.start,
+				End:     h.end,
+				Removed: diff.old[h.start:h.end],
+				Added:   h.lines,
+			})
+		}
+
+		diffs = append(diffs, diff)
+	}
+
+	return diffs, nil
+}
+
+// StageDiffs sets the index to HEAD plus the given changes: whole binary files, and for
+// text files the hunks listed, which may be a subset of the ones WorktreeDiff returned.
+func (r *gitRepository) StageDiffs(ctx context.Context, diffs []FileDiff) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Mode: git.MixedReset}); err != nil {
+		return fmt.Errorf("failed to reset index: %w", err)
+	}
+
+	for _, diff := range diffs {
+		if !diff.Binary && len(diff.Hunks) == 0 {
+			continue
+		}
+
+		staged := applyHunks(diff.old, 0, len(diff.old), toHunks(diff.Hunks))
+
+		current, err := util.ReadFile(worktree.Filesystem, diff.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", diff.Path, err)
+		}
+		missing := err != nil
+
+		// The whole change is selected, let git pick up the file as it is
+		if diff.Binary || (missing && staged == "") || (!missing && staged == string(current)) {
+			if _, err := worktree.Add(diff.Path); err != nil {
+				return fmt.Errorf("failed to stage %s: %w", diff.Path, err)
+			}
+			continue
+		}
+
+		if err := stagePartialFile(repo, diff.Path, []byte(staged)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stagePartialFile puts content in the index as path, keeping the mode it has in HEAD.
+func stagePartialFile(repo *git.Repository, path string, content []byte) error {
+	hash, err := writeBlob(repo, content)
+	if err != nil {
+		return err
+	}
+
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	entry, err := idx.Entry(path)
+	if err != nil {
+		// Partially selected files always exist in HEAD, the reset put them back in the index
+		return fmt.Errorf("failed to find %s in index: %w", path, err)
+	}
+
+	entry.Hash = hash
+	entry.Size = uint32(len(content))
+	if entry.Mode == filemode.Empty {
+		entry.Mode = filemode.Regular
+	}
+
+	if err := repo.Storer.SetIndex(idx); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	return nil
+}
+
+func toHunks(hunks []Hunk) []hunk {
+	sorted := make([]hunk, 0, len(hunks))
+	for _, h := range hunks {
+		sorted = append(sorted, hunk{start: h.Start, end: h.End, lines: h.Added})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+	return sorted
+}
+
+// headTree returns the tree of HEAD, nil before the first commit.
+func headTree(repo *git.Repository) (*object.Tree, error) {
+	head, err := repo.Head()
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
+	commit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	return commit.Tree()
+}
@@ -0,0 +1,291 @@
+package git
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-git/gcfg/v2"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// Identity is a name and an email, either of which may be empty when not configured.
+type Identity struct {
+	Name  string
+	Email string
+}
+
+// IdentityFunc returns an identity that takes precedence over git's own configuration.
+type IdentityFunc func() (Identity, error)
+
+type identityFactory struct {
+	RepositoryFactory
+	identity IdentityFunc
+}
+
+// WithIdentity returns a factory whose repositories commit as identity returns, where it
+// is set, instead of the identity configured in git. Environment variables still win.
+func WithIdentity(factory RepositoryFactory, identity IdentityFunc) RepositoryFactory {
+	return &identityFactory{RepositoryFactory: factory, identity: identity}
+}
+
+func (f *identityFactory) NewRepository(path string) Repository {
+	repo := f.RepositoryFactory.NewRepository(path)
+	if r, ok := repo.(*gitRepository); ok {
+		r.identity = f.identity
+	}
+	return repo
+}
+
+// configKeys are the git settings luna reads itself: the identity of commits and how
+// they are signed.
+var configKeys = []string{
+	"user.name", "user.email", "author.name", "author.email", "committer.name", "committer.email",
+	"commit.gpgsign", "gpg.format", "gpg.program", "gpg.openpgp.program", "gpg.ssh.program",
+	"gpg.ssh.allowedsignersfile", "user.signingkey",
+}
+
+// GetSignatures returns the author and committer of new commits. Like git, each comes from
+// GIT_AUTHOR_* or GIT_COMMITTER_* when set, then from the identity override, then from
+// author.* or committer.*, then user.* in the git config, and the email last from EMAIL.
+func (r *gitRepository) GetSignatures() (*object.Signature, *object.Signature, error) {
+	var override Identity
+	if r.identity != nil {
+		var err error
+		if override, err = r.identity(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	values, err := r.gitConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	when := time.Now()
+
+	author, err := resolveSignature("author", override, values, when)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	committer, err := resolveSignature("committer", override, values, when)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return author, committer, nil
+}
+
+func resolveSignature(role string, override Identity, values map[string]string, when time.Time) (*object.Signature, error) {
+	env := "GIT_" + strings.ToUpper(role)
+
+	name := firstNonEmpty(os.Getenv(env+"_NAME"), override.Name, values[role+".name"], values["user.name"])
+	if name == "" {
+		return nil, fmt.Errorf("no %s name configured - set it with 'luna config set --user user.name <name>' or 'git config --global user.name <name>'", role)
+	}
+
+	email := firstNonEmpty(os.Getenv(env+"_EMAIL"), override.Email, values[role+".email"], values["user.email"], os.Getenv("EMAIL"))
+	if email == "" {
+		return nil, fmt.Errorf("no %s email configured - set it with 'luna config set --user user.email <email>' or 'git config --global user.email <email>'", role)
+	}
+
+	return &object.Signature{Name: name, Email: email, When: when}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// gitConfig reads configKeys from the system, global and repository git config files,
+// later files overriding earlier ones, following include and includeIf.
+func (r *gitRepository) gitConfig() (map[string]string, error) {
+	var files []string
+
+	if !gitConfigBool(os.Getenv("GIT_CONFIG_NOSYSTEM")) {
+		files = append(files, firstNonEmpty(os.Getenv("GIT_CONFIG_SYSTEM"), "/etc/gitconfig"))
+	}
+
+	if global := os.Getenv("GIT_CONFIG_GLOBAL"); global != "" {
+		files = append(files, global)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		xdg := os.Getenv("XDG_CONFIG_HOME")
+		if xdg == "" {
+			xdg = filepath.Join(home, ".config")
+		}
+		files = append(files, filepath.Join(xdg, "git", "config"), filepath.Join(home, ".gitconfig"))
+	}
+
+	scope := &includeScope{}
+	if gitDir, commonDir, err := ResolveGitDirs(r.path); err == nil {
+		files = append(files, filepath.Join(commonDir, "config"))
+		scope.gitDir = gitDir
+		scope.branch = readHeadBranch(gitDir)
+	}
+
+	values := make(map[string]string)
+	for _, file := range files {
+		if err := readGitConfig(file, scope, values, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	return values, nil
+}
+
+// includeScope is what includeIf conditions are checked against.
+type includeScope struct {
+	gitDir string
+	branch string
+}
+
+// maxIncludeDepth stops include cycles, as git does.
+const maxIncludeDepth = 10
+
+// readGitConfig adds the configKeys set in a git config file to values. Like git, an
+// included file is read where its include is, so the settings after it override it.
+func readGitConfig(path string, scope *includeScope, values map[string]string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("too many nested includes in git config %s", path)
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read git config %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var includeErr error
+	err = gcfg.ReadWithCallback(f, func(section, subsection, name, value string, blank bool) error {
+		if name == "" {
+			return nil
+		}
+		// Sections and names are case insensitive, subsections are not
+		section, name = strings.ToLower(section), strings.ToLower(name)
+		if blank {
+			value = "true"
+		}
+
+		if name == "path" && (section == "include" && subsection == "" || section == "includeif" && scope.matches(subsection, path)) {
+			includeErr = readGitConfig(resolveConfigPath(value, path), scope, values, depth+1)
+			return includeErr
+		}
+
+		key := section + "." + name
+		if subsection != "" {
+			key = section + "." + subsection + "." + name
+		}
+		if slices.Contains(configKeys, key) {
+			values[key] = value
+		}
+		return nil
+	})
+	if includeErr != nil {
+		return includeErr
+	}
+	if err != nil {
+		return fmt.Errorf("invalid git config %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// matches reports whether an includeIf condition holds: gitdir:, gitdir/i: and onbranch:.
+func (s *includeScope) matches(condition, configPath string) bool {
+	kind, pattern, found := strings.Cut(condition, ":")
+	if !found || pattern == "" {
+		return false
+	}
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		if s.gitDir == "" {
+			return false
+		}
+		directory := strings.HasSuffix(pattern, "/")
+		switch {
+		case strings.HasPrefix(pattern, "./"):
+			pattern = filepath.Join(filepath.Dir(configPath), pattern[2:])
+		case strings.HasPrefix(pattern, "~/"):
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return false
+			}
+			pattern = filepath.Join(home, pattern[2:])
+		case !strings.HasPrefix(pattern, "/"):
+			pattern = "**/" + pattern
+		}
+		if directory && !strings.HasSuffix(pattern, "/") {
+			pattern += "/"
+		}
+		return matchGitGlob(filepath.ToSlash(pattern), filepath.ToSlash(s.gitDir), kind == "gitdir/i")
+	case "onbranch":
+		return s.branch != "" && matchGitGlob(pattern, s.branch, false)
+	}
+
+	return false
+}
+
+// matchGitGlob matches like git's wildmatch: * and ? stay within a path component, **
+// crosses them, and a pattern ending with / matches everything below it.
+func matchGitGlob(pattern, value string, foldCase bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		pattern += "**"
+	}
+
+	var expr strings.Builder
+	if foldCase {
+		expr.WriteString("(?i)")
+	}
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), value)
+	return err == nil && matched
+}
+
+// resolveConfigPath resolves an include path: ~/ is the home directory and relative paths
+// are relative to the including file.
+func resolveConfigPath(include, from string) string {
+	include = expandHome(include)
+	if filepath.IsAbs(include) {
+		return include
+	}
+	return filepath.Join(filepath.Dir(from), include)
+}
+
+func gitConfigBool(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true", "yes", "on":
+		return true
+	}
+	return false
+}
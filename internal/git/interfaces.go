@@ -10,11 +10,12 @@ import (
 
 // Repository represents a git repository interface for testability.
 type Repository interface {
-	// Init initializes a new git repository at the specified path.
+	// Init initializes a new git repository at the specified path, with an initial commit
+	// carrying message, and checks out the trunk branch.
 	// Returns an error if initialization fails or if a repository already exists.
-	Init(ctx context.Context, path string) error
+	Init(ctx context.Context, path, trunk, message string) error
 
-	// IsRepository checks if the given path is already a git repository.
+	// IsRepository checks if the given path is already a git repository, linked worktrees included.
 	IsRepository(path string) (bool, error)
 
 	// GetPath returns the root path of the repository.
@@ -29,20 +30,148 @@ type Repository interface {
 	// StageAll stages all changes in the working directory.
 	StageAll(ctx context.Context) error
 
+	// WorktreeDiff returns the line hunks of every file changed in the worktree against HEAD.
+	WorktreeDiff(ctx context.Context) ([]FileDiff, error)
+
+	// StageDiffs sets the index to HEAD plus the given files and hunks, leaving the rest of
+	// the changes in the worktree only.
+	StageDiffs(ctx context.Context, diffs []FileDiff) error
+
 	// Commit creates a commit with the given message and returns the commit hash.
 	Commit(ctx context.Context, message string) (string, error)
 
 	// GetCurrentBranch returns the name of the current branch.
 	GetCurrentBranch(ctx context.Context) (string, error)
 
-	// SquashAndRebase squashes all commits from current branch since baseBranch and rebases onto baseBranch.
-	SquashAndRebase(ctx context.Context, baseBranch, commitMessage string) error
+	// SquashAndRebase squashes all commits from current branch since baseBranch and rebases onto baseBranch,
+	// returning the hash of the squashed commit.
+	// Changes landed on baseBranch in the meantime are kept through a three-way merge.
+	// When both sides changed the same hunks a *ConflictError is returned, the branch is left
+	// untouched and the worktree holds the merged files with conflict markers.
+	SquashAndRebase(ctx context.Context, baseBranch, commitMessage string) (string, error)
+
+	// ContinueSquashAndRebase lands the resolved index as the squashed commit on top of baseHash
+	// and returns its hash.
+	// It fails if baseBranch no longer points at baseHash.
+	ContinueSquashAndRebase(ctx context.Context, baseBranch, baseHash, commitMessage string) (string, error)
+
+	// RestoreBranch checks out branchName at tipHash with the worktree of worktreeHash left as uncommitted changes.
+	RestoreBranch(ctx context.Context, branchName, tipHash, worktreeHash string) error
+
+	// DetachHead checks out hash without a branch, discarding uncommitted changes.
+	DetachHead(ctx context.Context, hash string) error
+
+	// ListBranchCommits returns the commits of branchName since it forked from baseBranch, oldest first.
+	ListBranchCommits(ctx context.Context, branchName, baseBranch string) ([]string, error)
+
+	// ReplayCommits re-applies commits on top of ontoHash, a commit of baseBranch, and moves
+	// branchName to the result. It returns the mapping from original to rewritten hashes. When a
+	// commit does not apply cleanly, branchName is checked out with conflict markers and a
+	// *ConflictError is returned along with the commits replayed so far.
+	ReplayCommits(ctx context.Context, branchName, baseBranch, ontoHash string, commits []string) (map[string]string, error)
+
+	// CommitResolved commits the index on top of parentHash reusing the author and message of originalHash.
+	// No reference is updated.
+	CommitResolved(ctx context.Context, originalHash, parentHash string) (string, error)
+
+	// IsClean reports whether the worktree and index match HEAD.
+	IsClean(ctx context.Context) (bool, error)
+
+	// SaveWIP parks the uncommitted changes of the checked out branch in a hidden commit
+	// and cleans the worktree. It returns false if there was nothing to save.
+	SaveWIP(ctx context.Context, branchName string) (bool, error)
+
+	// RestoreWIP brings back the parked changes of the checked out branch as uncommitted changes.
+	// It returns false if there was nothing to restore, and the paths that conflicted with
+	// commits made on the branch since the snapshot.
+	RestoreWIP(ctx context.Context, branchName string) (bool, []string, error)
+
+	// SnapshotWorktree records the worktree, untracked files included, in a commit on top of
+	// HEAD without touching the index or any reference. It returns "" if the worktree is clean.
+	SnapshotWorktree(ctx context.Context) (string, error)
+
+	// CarrySnapshot moves the uncommitted changes a snapshot records, its difference with
+	// its parent, on top of tipHash. It returns the snapshot of tipHash with them and the
+	// paths where they conflict, left with conflict markers.
+	CarrySnapshot(ctx context.Context, snapshotHash, tipHash string) (string, []string, error)
+
+	// GetConfig returns a "section.key" or "section.subsection.key" value from the repository
+	// config, "" if it is not set.
+	GetConfig(ctx context.Context, key string) (string, error)
+
+	// SetConfig sets a value in the repository config.
+	SetConfig(ctx context.Context, key, value string) error
+
+	// UnsetConfig removes a value from the repository config.
+	UnsetConfig(ctx context.Context, key string) error
+
+	// ReadRefFile returns a file from the tree of the commit refName points to, nil if the
+	// reference or the file does not exist.
+	ReadRefFile(ctx context.Context, refName, path string) ([]byte, error)
+
+	// WriteRefFile commits data as path on top of refName, keeping the history of the file.
+	WriteRefFile(ctx context.Context, refName, path string, data []byte, message string) error
+
+	// ListWorktrees returns the main worktree followed by the linked worktrees of the repository.
+	ListWorktrees(ctx context.Context) ([]WorktreeInfo, error)
+
+	// AddWorktree creates a linked worktree at path with branchName checked out.
+	AddWorktree(ctx context.Context, path, branchName string) error
+
+	// RemoveWorktree deletes the linked worktree at path, its files included.
+	RemoveWorktree(ctx context.Context, path string) error
+
+	// DeleteBranch removes the given local branch.
+	DeleteBranch(ctx context.Context, branchName string) error
+
+	// GetReference returns the hash a full reference name points to, or "" if it does not exist.
+	GetReference(ctx context.Context, refName string) (string, error)
+
+	// SetReference points a full reference name at the given hash, creating it if needed.
+	SetReference(ctx context.Context, refName, hash string) error
+
+	// RemoveReference deletes a full reference name.
+	RemoveReference(ctx context.Context, refName string) error
+
+	// ListReferences returns the hash of every reference whose full name starts with prefix.
+	ListReferences(ctx context.Context, prefix string) (map[string]string, error)
+
+	// ResolveRevision returns the commit hash a revision (branch, reference or abbreviated hash) points to.
+	ResolveRevision(ctx context.Context, revision string) (string, error)
+
+	// ListBranches returns the short names of all local branches.
+	ListBranches(ctx context.Context) ([]string, error)
+
+	// AheadBehind returns how many commits branchName has that baseBranch lacks, and the reverse.
+	AheadBehind(ctx context.Context, branchName, baseBranch string) (int, int, error)
+
+	// GetBranchHash returns the commit hash the given branch points to.
+	GetBranchHash(ctx context.Context, branchName string) (string, error)
 
 	// HasStagedChanges checks if there are any staged changes ready to commit.
 	HasStagedChanges(ctx context.Context) (bool, error)
 
-	// GetUserSignature returns the user's git signature from global config.
-	GetUserSignature() (*object.Signature, error)
+	// GetCommit returns the details of the given commit.
+	GetCommit(ctx context.Context, hash string) (*CommitInfo, error)
+
+	// Log returns the first-parent history of branchName, newest first. A limit of 0 means no limit.
+	Log(ctx context.Context, branchName string, limit int) ([]CommitInfo, error)
+
+	// DiffStat returns the lines added and deleted per file by a commit against its first parent.
+	DiffStat(ctx context.Context, hash string) ([]FileStat, error)
+
+	// Status returns the staged, unstaged and untracked changes of the worktree against HEAD.
+	Status(ctx context.Context) (*WorktreeStatus, error)
+
+	// VerifyCommit checks the signature of a commit with gpg or ssh-keygen, as configured in git.
+	VerifyCommit(ctx context.Context, hash string) (*SignatureCheck, error)
+
+	// SignsCommits reports whether new commits are signed, as commit.gpgsign asks.
+	SignsCommits() (bool, error)
+
+	// GetSignatures returns the author and committer of new commits, from the environment,
+	// the identity override and the git config.
+	GetSignatures() (*object.Signature, *object.Signature, error)
 }
 
 // RepositoryFactory creates Repository instances.
@@ -0,0 +1,120 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// CommitInfo is the subset of a commit luna displays.
+type CommitInfo struct {
+	Hash        string
+	Message     string
+	AuthorName  string
+	AuthorEmail string
+	When        time.Time
+	Parents     []string
+}
+
+// Subject returns the first line of the commit message.
+func (c CommitInfo) Subject() string {
+	line, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+	return line
+}
+
+// FileStat is the number of lines added and deleted in a file by a commit.
+type FileStat struct {
+	Path      string
+	Additions int
+	Deletions int
+}
+
+func newCommitInfo(commit *object.Commit) *CommitInfo {
+	parents := make([]string, 0, len(commit.ParentHashes))
+	for _, parent := range commit.ParentHashes {
+		parents = append(parents, parent.String())
+	}
+
+	return &CommitInfo{
+		Hash:        commit.Hash.String(),
+		Message:     commit.Message,
+		AuthorName:  commit.Author.Name,
+		AuthorEmail: commit.Author.Email,
+		When:        commit.Author.When,
+		Parents:     parents,
+	}
+}
+
+func (r *gitRepository) GetCommit(ctx context.Context, hash string) (*CommitInfo, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	return newCommitInfo(commit), nil
+}
+
+func (r *gitRepository) Log(ctx context.Context, branchName string, limit int) ([]CommitInfo, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch %s: %w", branchName, err)
+	}
+
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch commit: %w", err)
+	}
+
+	var commits []CommitInfo
+	for {
+		commits = append(commits, *newCommitInfo(commit))
+		if commit.NumParents() == 0 || (limit > 0 && len(commits) >= limit) {
+			break
+		}
+
+		commit, err = commit.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk history: %w", err)
+		}
+	}
+
+	return commits, nil
+}
+
+func (r *gitRepository) DiffStat(ctx context.Context, hash string) ([]FileStat, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	stats, err := commit.StatsContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute diffstat of %s: %w", hash, err)
+	}
+
+	result := make([]FileStat, 0, len(stats))
+	for _, stat := range stats {
+		result = append(result, FileStat{Path: stat.Name, Additions: stat.Addition, Deletions: stat.Deletion})
+	}
+
+	return result, nil
+}
@@ -0,0 +1,570 @@
+package git
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+// ConflictError is returned when a three-way merge touches the same hunks on both sides.
+type ConflictError struct {
+	Paths []string
+	// TargetHash is the tip of the branch the workspace was being merged onto.
+	TargetHash string
+	// Commit is the workspace commit being replayed when the conflict happened, if any.
+	Commit string
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("merge conflict in %d file(s): %s", len(e.Paths), strings.Join(e.Paths, ", "))
+}
+
+// mergeConflict describes a single path that could not be merged automatically.
+type mergeConflict struct {
+	Path    string
+	Content []byte
+	Mode    filemode.FileMode
+}
+
+// mergeResult holds the outcome of a three-way tree merge.
+type mergeResult struct {
+	TreeHash  plumbing.Hash
+	Conflicts []mergeConflict
+}
+
+func (m *mergeResult) conflictPaths() []string {
+	paths := make([]string, 0, len(m.Conflicts))
+	for _, c := range m.Conflicts {
+		paths = append(paths, c.Path)
+	}
+	return paths
+}
+
+// mergeBase returns the best common ancestor of the two commits.
+func mergeBase(a, b *object.Commit) (*object.Commit, error) {
+	bases, err := a.MergeBase(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute merge base: %w", err)
+	}
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("commits %s and %s have no common ancestor", a.Hash, b.Hash)
+	}
+	return bases[0], nil
+}
+
+// mergeTrees applies the changes between base and theirs on top of ours.
+// The merged tree is always written, conflicted paths keep ours with conflict markers.
+func mergeTrees(repo *git.Repository, base, ours, theirs *object.Tree, oursLabel, theirsLabel string) (*mergeResult, error) {
+	baseEntries, err := flattenTree(base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base tree: %w", err)
+	}
+	oursEntries, err := flattenTree(ours)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read target tree: %w", err)
+	}
+	theirsEntries, err := flattenTree(theirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read workspace tree: %w", err)
+	}
+
+	paths := make(map[string]struct{})
+	for _, entries := range []map[string]object.TreeEntry{baseEntries, oursEntries, theirsEntries} {
+		for p := range entries {
+			paths[p] = struct{}{}
+		}
+	}
+
+	sortedPaths := make([]string, 0, len(paths))
+	for p := range paths {
+		sortedPaths = append(sortedPaths, p)
+	}
+	sort.Strings(sortedPaths)
+
+	merged := make(map[string]object.TreeEntry)
+	result := &mergeResult{}
+
+	for _, p := range sortedPaths {
+		b, inBase := baseEntries[p]
+		o, inOurs := oursEntries[p]
+		t, inTheirs := theirsEntries[p]
+
+		switch {
+		case sameEntry(o, inOurs, t, inTheirs):
+			if inOurs {
+				merged[p] = o
+			}
+		case sameEntry(b, inBase, o, inOurs):
+			if inTheirs {
+				merged[p] = t
+			}
+		case sameEntry(b, inBase, t, inTheirs):
+			if inOurs {
+				merged[p] = o
+			}
+		case !inOurs || !inTheirs:
+			// Modified on one side, deleted on the other
+			kept := o
+			if !inOurs {
+				kept = t
+			}
+			merged[p] = kept
+			content, err := readBlob(repo, kept.Hash)
+			if err != nil {
+				return nil, err
+			}
+			result.Conflicts = append(result.Conflicts, mergeConflict{Path: p, Content: content, Mode: kept.Mode})
+		default:
+			entry, conflict, err := mergeEntry(repo, p, b, inBase, o, t, oursLabel, theirsLabel)
+			if err != nil {
+				return nil, err
+			}
+			merged[p] = entry
+			if conflict != nil {
+				result.Conflicts = append(result.Conflicts, *conflict)
+			}
+		}
+	}
+
+	if err := moveAsideFileDirectoryConflicts(repo, merged, result, oursEntries, oursLabel, theirsLabel); err != nil {
+		return nil, err
+	}
+
+	treeHash, err := writeTree(repo, merged)
+	if err != nil {
+		return nil, err
+	}
+	result.TreeHash = treeHash
+
+	return result, nil
+}
+
+// moveAsideFileDirectoryConflicts finds the files of the merge that are also the directory
+// of other files, "a" and "a/b" when one side turned a into a directory. Like git, the
+// directory stays and the file moves aside to "a~<label>" of the side it comes from, with
+// "a" reported as conflicted.
+func moveAsideFileDirectoryConflicts(repo *git.Repository, merged map[string]object.TreeEntry, result *mergeResult, oursEntries map[string]object.TreeEntry, oursLabel, theirsLabel string) error {
+	files := make(map[string]bool)
+	for p := range merged {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			if _, isFile := merged[dir]; isFile {
+				files[dir] = true
+			}
+		}
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	// A file that also failed to merge is reported once
+	conflicts := result.Conflicts[:0]
+	for _, conflict := range result.Conflicts {
+		if !files[conflict.Path] {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+
+	for file := range files {
+		entry := merged[file]
+		label := theirsLabel
+		if ours, inOurs := oursEntries[file]; inOurs && ours.Hash == entry.Hash {
+			label = oursLabel
+		}
+
+		content, err := readBlob(repo, entry.Hash)
+		if err != nil {
+			return err
+		}
+
+		delete(merged, file)
+		merged[file+"~"+strings.ReplaceAll(label, "/", "_")] = entry
+		conflicts = append(conflicts, mergeConflict{Path: file, Content: content, Mode: entry.Mode})
+	}
+
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	result.Conflicts = conflicts
+
+	return nil
+}
+
+func mergeEntry(repo *git.Repository, path string, base object.TreeEntry, inBase bool, ours, theirs object.TreeEntry, oursLabel, theirsLabel string) (object.TreeEntry, *mergeConflict, error) {
+	mode := ours.Mode
+	modeConflict := false
+	if ours.Mode != theirs.Mode {
+		switch {
+		case inBase && base.Mode == ours.Mode:
+			mode = theirs.Mode
+		case inBase && base.Mode == theirs.Mode:
+			mode = ours.Mode
+		default:
+			modeConflict = true
+		}
+	}
+
+	oursContent, err := readBlob(repo, ours.Hash)
+	if err != nil {
+		return object.TreeEntry{}, nil, err
+	}
+
+	if !mode.IsFile() || !ours.Mode.IsFile() || !theirs.Mode.IsFile() {
+		return ours, &mergeConflict{Path: path, Content: oursContent, Mode: ours.Mode}, nil
+	}
+
+	var baseContent []byte
+	if inBase && base.Mode.IsFile() {
+		baseContent, err = readBlob(repo, base.Hash)
+		if err != nil {
+			return object.TreeEntry{}, nil, err
+		}
+	}
+
+	theirsContent, err := readBlob(repo, theirs.Hash)
+	if err != nil {
+		return object.TreeEntry{}, nil, err
+	}
+
+	if isBinary(baseContent) || isBinary(oursContent) || isBinary(theirsContent) {
+		return ours, &mergeConflict{Path: path, Content: oursContent, Mode: ours.Mode}, nil
+	}
+
+	content, clean := mergeLines(string(baseContent), string(oursContent), string(theirsContent), oursLabel, theirsLabel)
+
+	hash, err := writeBlob(repo, []byte(content))
+	if err != nil {
+		return object.TreeEntry{}, nil, err
+	}
+
+	entry := object.TreeEntry{Name: path, Mode: mode, Hash: hash}
+	if !clean || modeConflict {
+		return entry, &mergeConflict{Path: path, Content: []byte(content), Mode: mode}, nil
+	}
+
+	return entry, nil, nil
+}
+
+func sameEntry(a object.TreeEntry, inA bool, b object.TreeEntry, inB bool) bool {
+	if inA != inB {
+		return false
+	}
+	if !inA {
+		return true
+	}
+	return a.Hash == b.Hash && a.Mode == b.Mode
+}
+
+// flattenTree returns every non-directory entry of the tree keyed by its full path.
+func flattenTree(tree *object.Tree) (map[string]object.TreeEntry, error) {
+	entries := make(map[string]object.TreeEntry)
+	if tree == nil {
+		return entries, nil
+	}
+
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		entry.Name = name
+		entries[name] = entry
+	}
+
+	return entries, nil
+}
+
+// writeTree stores the nested tree objects for a flat path to entry map and returns the root hash.
+func writeTree(repo *git.Repository, entries map[string]object.TreeEntry) (plumbing.Hash, error) {
+	files := make([]object.TreeEntry, 0)
+	dirs := make(map[string]map[string]object.TreeEntry)
+
+	for p, entry := range entries {
+		if i := strings.IndexByte(p, '/'); i >= 0 {
+			dir := p[:i]
+			if dirs[dir] == nil {
+				dirs[dir] = make(map[string]object.TreeEntry)
+			}
+			dirs[dir][p[i+1:]] = entry
+			continue
+		}
+		entry.Name = p
+		files = append(files, entry)
+	}
+
+	for dir, children := range dirs {
+		hash, err := writeTree(repo, children)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		files = append(files, object.TreeEntry{Name: dir, Mode: filemode.Dir, Hash: hash})
+	}
+
+	sort.Sort(object.TreeEntrySorter(files))
+
+	tree := &object.Tree{Entries: files}
+	obj := repo.Storer.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode tree: %w", err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store tree: %w", err)
+	}
+
+	return hash, nil
+}
+
+// writeIndexTree stores the tree described by the current index and returns its hash.
+func writeIndexTree(repo *git.Repository) (plumbing.Hash, error) {
+	idx, err := repo.Storer.Index()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read index: %w", err)
+	}
+
+	entries := make(map[string]object.TreeEntry, len(idx.Entries))
+	for _, e := range idx.Entries {
+		entries[e.Name] = object.TreeEntry{Name: e.Name, Mode: e.Mode, Hash: e.Hash}
+	}
+
+	return writeTree(repo, entries)
+}
+
+func readBlob(repo *git.Repository, hash plumbing.Hash) ([]byte, error) {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blob %s: %w", hash, err)
+	}
+
+	reader, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", hash, err)
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func writeBlob(repo *git.Repository, content []byte) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	obj.SetSize(int64(len(content)))
+
+	writer, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to open blob writer: %w", err)
+	}
+	if _, err := writer.Write(content); err != nil {
+		writer.Close()
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := writer.Close(); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return hash, nil
+}
+
+func isBinary(content []byte) bool {
+	if len(content) > 8000 {
+		content = content[:8000]
+	}
+	return bytes.IndexByte(content, 0) >= 0
+}
+
+// hunk replaces base lines [start, end) with lines.
+type hunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// mergeLines performs a diff3 style merge and reports whether it was conflict free.
+func mergeLines(base, ours, theirs, oursLabel, theirsLabel string) (string, bool) {
+	baseLines := splitLines(base)
+	oursHunks := diffHunks(baseLines, splitLines(ours))
+	theirsHunks := diffHunks(baseLines, splitLines(theirs))
+
+	var out strings.Builder
+	clean := true
+	pos := 0
+	i, j := 0, 0
+
+	for i < len(oursHunks) || j < len(theirsHunks) {
+		// Start a region from the hunk that begins first and grow it with every overlapping hunk
+		var start, end int
+		if j >= len(theirsHunks) || (i < len(oursHunks) && oursHunks[i].start <= theirsHunks[j].start) {
+			start, end = oursHunks[i].start, oursHunks[i].end
+		} else {
+			start, end = theirsHunks[j].start, theirsHunks[j].end
+		}
+
+		oi, tj := i, j
+		for {
+			grown := false
+			for i < len(oursHunks) && oursHunks[i].start <= end {
+				end = max(end, oursHunks[i].end)
+				i++
+				grown = true
+			}
+			for j < len(theirsHunks) && theirsHunks[j].start <= end {
+				end = max(end, theirsHunks[j].end)
+				j++
+				grown = true
+			}
+			if !grown {
+				break
+			}
+		}
+
+		for _, line := range baseLines[pos:start] {
+			out.WriteString(line)
+		}
+		pos = end
+
+		oursRegion := applyHunks(baseLines, start, end, oursHunks[oi:i])
+		theirsRegion := applyHunks(baseLines, start, end, theirsHunks[tj:j])
+
+		switch {
+		case tj == j:
+			out.WriteString(oursRegion)
+		case oi == i:
+			out.WriteString(theirsRegion)
+		case oursRegion == theirsRegion:
+			out.WriteString(oursRegion)
+		default:
+			clean = false
+			out.WriteString("<<<<<<< " + oursLabel + "\n")
+			out.WriteString(withTrailingNewline(oursRegion))
+			out.WriteString("=======\n")
+			out.WriteString(withTrailingNewline(theirsRegion))
+			out.WriteString(">>>>>>> " + theirsLabel + "\n")
+		}
+	}
+
+	for _, line := range baseLines[pos:] {
+		out.WriteString(line)
+	}
+
+	return out.String(), clean
+}
+
+func applyHunks(baseLines []string, start, end int, hunks []hunk) string {
+	var out strings.Builder
+	pos := start
+	for _, h := range hunks {
+		for _, line := range baseLines[pos:h.start] {
+			out.WriteString(line)
+		}
+		for _, line := range h.lines {
+			out.WriteString(line)
+		}
+		pos = h.end
+	}
+	for _, line := range baseLines[pos:end] {
+		out.WriteString(line)
+	}
+	return out.String()
+}
+
+func withTrailingNewline(s string) string {
+	if s != "" && !strings.HasSuffix(s, "\n") {
+		return s + "\n"
+	}
+	return s
+}
+
+// splitLines splits text into lines, keeping the line terminators.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffHunks computes the line hunks turning base into other, in base coordinates.
+func diffHunks(baseLines, otherLines []string) []hunk {
+	ids := make(map[string]rune)
+	encode := func(lines []string) []rune {
+		runes := make([]rune, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = rune(len(ids) + 1)
+				// Skip the surrogate range so runes survive string conversions
+				if id >= 0xD800 {
+					id += 0x800
+				}
+				ids[line] = id
+			}
+			runes[i] = id
+		}
+		return runes
+	}
+
+	dmp := diffmatchpatch.New()
+	dmp.DiffTimeout = time.Hour
+	diffs := dmp.DiffMainRunes(encode(baseLines), encode(otherLines), false)
+
+	var hunks []hunk
+	var current *hunk
+	basePos, otherPos := 0, 0
+
+	flush := func() {
+		if current != nil {
+			hunks = append(hunks, *current)
+			current = nil
+		}
+	}
+
+	for _, d := range diffs {
+		n := len([]rune(d.Text))
+		switch d.Type {
+		case diffmatchpatch.DiffEqual:
+			flush()
+			basePos += n
+			otherPos += n
+		case diffmatchpatch.DiffDelete:
+			if current == nil {
+				current = &hunk{start: basePos, end: basePos}
+			}
+			basePos += n
+			current.end = basePos
+		case diffmatchpatch.DiffInsert:
+			if current == nil {
+				current = &hunk{start: basePos, end: basePos}
+			}
+			current.lines = append(current.lines, otherLines[otherPos:otherPos+n]...)
+			otherPos += n
+		}
+	}
+	flush()
+
+	return hunks
+}
@@ -0,0 +1,246 @@
+package git
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/go-git/go-git/v6/storage/memory"
+)
+
+func TestMergeLines(t *testing.T) {
+	const base = "one\ntwo\nthree\nfour\nfive\n"
+
+	tests := []struct {
+		name   string
+		ours   string
+		theirs string
+		want   string
+		clean  bool
+	}{
+		{
+			name:   "non-overlapping edits",
+			ours:   "ONE\ntwo\nthree\nfour\nfive\n",
+			theirs: "one\ntwo\nthree\nfour\nFIVE\n",
+			want:   "ONE\ntwo\nthree\nfour\nFIVE\n",
+			clean:  true,
+		},
+		{
+			name:   "edit on one side only",
+			ours:   base,
+			theirs: "one\ntwo\nTHREE\nfour\nfive\n",
+			want:   "one\ntwo\nTHREE\nfour\nfive\n",
+			clean:  true,
+		},
+		{
+			name:   "same edit on both sides",
+			ours:   "one\nTWO\nthree\nfour\nfive\n",
+			theirs: "one\nTWO\nthree\nfour\nfive\n",
+			want:   "one\nTWO\nthree\nfour\nfive\n",
+			clean:  true,
+		},
+		{
+			name:   "overlapping edits",
+			ours:   "one\ntwo\nours\nfour\nfive\n",
+			theirs: "one\ntwo\ntheirs\nfour\nfive\n",
+			want:   "one\ntwo\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\nfour\nfive\n",
+		},
+		{
+			name:   "adjacent edits",
+			ours:   "one\nTWO\nthree\nfour\nfive\n",
+			theirs: "one\ntwo\nTHREE\nfour\nfive\n",
+			want:   "one\n<<<<<<< ours\nTWO\nthree\n=======\ntwo\nTHREE\n>>>>>>> theirs\nfour\nfive\n",
+		},
+		{
+			name:   "different insertions at the same place",
+			ours:   "one\nours\ntwo\nthree\nfour\nfive\n",
+			theirs: "one\ntheirs\ntwo\nthree\nfour\nfive\n",
+			want:   "one\n<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\ntwo\nthree\nfour\nfive\n",
+		},
+		{
+			name:   "deletion against an edit of the same line",
+			ours:   "one\ntwo\nfour\nfive\n",
+			theirs: "one\ntwo\nTHREE\nfour\nfive\n",
+			want:   "one\ntwo\n<<<<<<< ours\n=======\nTHREE\n>>>>>>> theirs\nfour\nfive\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clean := mergeLines(base, tt.ours, tt.theirs, "ours", "theirs")
+			if got != tt.want || clean != tt.clean {
+				t.Fatalf("mergeLines() = %q, %v\nwant %q, %v", got, clean, tt.want, tt.clean)
+			}
+		})
+	}
+}
+
+func TestMergeTrees(t *testing.T) {
+	tests := []struct {
+		name      string
+		base      map[string]string
+		ours      map[string]string
+		theirs    map[string]string
+		want      map[string]string
+		conflicts []string
+	}{
+		{
+			name:   "edits to different files",
+			base:   map[string]string{"a": "a\n", "b": "b\n"},
+			ours:   map[string]string{"a": "ours\n", "b": "b\n"},
+			theirs: map[string]string{"a": "a\n", "b": "theirs\n", "dir/c": "c\n"},
+			want:   map[string]string{"a": "ours\n", "b": "theirs\n", "dir/c": "c\n"},
+		},
+		{
+			name:   "non-overlapping edits to one file",
+			base:   map[string]string{"a": "one\ntwo\nthree\n"},
+			ours:   map[string]string{"a": "ONE\ntwo\nthree\n"},
+			theirs: map[string]string{"a": "one\ntwo\nTHREE\n"},
+			want:   map[string]string{"a": "ONE\ntwo\nTHREE\n"},
+		},
+		{
+			name:   "deleted on both sides",
+			base:   map[string]string{"a": "a\n", "b": "b\n"},
+			ours:   map[string]string{"b": "b\n"},
+			theirs: map[string]string{"b": "b\n"},
+			want:   map[string]string{"b": "b\n"},
+		},
+		{
+			name:      "deleted on our side, modified on theirs",
+			base:      map[string]string{"a": "a\n", "b": "b\n"},
+			ours:      map[string]string{"b": "b\n"},
+			theirs:    map[string]string{"a": "modified\n", "b": "b\n"},
+			want:      map[string]string{"a": "modified\n", "b": "b\n"},
+			conflicts: []string{"a"},
+		},
+		{
+			name:      "modified on our side, deleted on theirs",
+			base:      map[string]string{"a": "a\n", "b": "b\n"},
+			ours:      map[string]string{"a": "modified\n", "b": "b\n"},
+			theirs:    map[string]string{"b": "b\n"},
+			want:      map[string]string{"a": "modified\n", "b": "b\n"},
+			conflicts: []string{"a"},
+		},
+		{
+			name:   "added on both sides with the same content",
+			base:   map[string]string{"b": "b\n"},
+			ours:   map[string]string{"a": "same\n", "b": "b\n"},
+			theirs: map[string]string{"a": "same\n", "b": "b\n"},
+			want:   map[string]string{"a": "same\n", "b": "b\n"},
+		},
+		{
+			name:      "added on both sides with different content",
+			base:      map[string]string{"b": "b\n"},
+			ours:      map[string]string{"a": "ours\n", "b": "b\n"},
+			theirs:    map[string]string{"a": "theirs\n", "b": "b\n"},
+			want:      map[string]string{"a": "<<<<<<< luna\nours\n=======\ntheirs\n>>>>>>> ws/feat\n", "b": "b\n"},
+			conflicts: []string{"a"},
+		},
+		{
+			name:      "file on our side, directory on theirs",
+			base:      map[string]string{"a": "a\n"},
+			ours:      map[string]string{"a": "modified\n"},
+			theirs:    map[string]string{"a/b": "b\n"},
+			want:      map[string]string{"a/b": "b\n", "a~luna": "modified\n"},
+			conflicts: []string{"a"},
+		},
+		{
+			name:      "directory on our side, file on theirs",
+			base:      map[string]string{"keep": "keep\n"},
+			ours:      map[string]string{"a/b/c": "c\n", "keep": "keep\n"},
+			theirs:    map[string]string{"a/b": "b\n", "keep": "keep\n"},
+			want:      map[string]string{"a/b/c": "c\n", "a/b~ws_feat": "b\n", "keep": "keep\n"},
+			conflicts: []string{"a/b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, err := git.Init(memory.NewStorage())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := mergeTrees(repo, testTree(t, repo, tt.base), testTree(t, repo, tt.ours), testTree(t, repo, tt.theirs), "luna", "ws/feat")
+			if err != nil {
+				t.Fatalf("mergeTrees: %v", err)
+			}
+
+			if got := result.conflictPaths(); !slices.Equal(got, tt.conflicts) {
+				t.Errorf("conflicts = %v, want %v", got, tt.conflicts)
+			}
+
+			merged, err := repo.TreeObject(result.TreeHash)
+			if err != nil {
+				t.Fatalf("merged tree: %v", err)
+			}
+			if got := treeFiles(t, repo, merged); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("merged tree = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testTree stores a tree of regular files keyed by their path.
+func testTree(t *testing.T, repo *git.Repository, files map[string]string) *object.Tree {
+	t.Helper()
+
+	entries := make(map[string]object.TreeEntry, len(files))
+	for path, content := range files {
+		hash, err := writeBlob(repo, []byte(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[path] = object.TreeEntry{Name: path, Mode: filemode.Regular, Hash: hash}
+	}
+
+	hash, err := writeTree(repo, entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := repo.TreeObject(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tree
+}
+
+// treeFiles returns the content of every file of a tree keyed by its path, and fails when a
+// name holds both a file and a directory.
+func treeFiles(t *testing.T, repo *git.Repository, tree *object.Tree) map[string]string {
+	t.Helper()
+
+	seen := make(map[string]bool)
+	for _, entry := range tree.Entries {
+		if seen[entry.Name] {
+			t.Fatalf("tree holds '%s' twice", entry.Name)
+		}
+		seen[entry.Name] = true
+	}
+
+	entries, err := flattenTree(tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := make(map[string]string, len(entries))
+	for path, entry := range entries {
+		content, err := readBlob(repo, entry.Hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[path] = string(content)
+		if strings.Contains(path, "/") {
+			dir := path[:strings.LastIndex(path, "/")]
+			if _, isFile := entries[dir]; isFile {
+				t.Fatalf("'%s' is both a file and a directory", dir)
+			}
+		}
+	}
+	return files
+}
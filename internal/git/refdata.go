@@ -0,0 +1,114 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/filemode"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// MetaRefPrefix holds workspace metadata when it is stored in git rather than in .git/metadata.json.
+const MetaRefPrefix = "refs/luna/meta/"
+
+func (r *gitRepository) ReadRefFile(ctx context.Context, refName, path string) ([]byte, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reference %s: %w", refName, err)
+	}
+
+	commit, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit of %s: %w", refName, err)
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree of %s: %w", refName, err)
+	}
+
+	entry, err := tree.FindEntry(path)
+	if err == object.ErrEntryNotFound || err == object.ErrDirectoryNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find %s in %s: %w", path, refName, err)
+	}
+
+	return readBlob(repo, entry.Hash)
+}
+
+func (r *gitRepository) WriteRefFile(ctx context.Context, refName, path string, data []byte, message string) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	entries := make(map[string]object.TreeEntry)
+	var parents []plumbing.Hash
+
+	ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
+	if err != nil && err != plumbing.ErrReferenceNotFound {
+		return fmt.Errorf("failed to get reference %s: %w", refName, err)
+	}
+	if err == nil {
+		parent, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("failed to get commit of %s: %w", refName, err)
+		}
+
+		tree, err := parent.Tree()
+		if err != nil {
+			return fmt.Errorf("failed to get tree of %s: %w", refName, err)
+		}
+
+		if entries, err = flattenTree(tree); err != nil {
+			return err
+		}
+		parents = append(parents, parent.Hash)
+	}
+
+	blobHash, err := writeBlob(repo, data)
+	if err != nil {
+		return err
+	}
+	entries[path] = object.TreeEntry{Name: path, Mode: filemode.Regular, Hash: blobHash}
+
+	treeHash, err := writeTree(repo, entries)
+	if err != nil {
+		return err
+	}
+
+	author := object.Signature{Name: "Luna", Email: "luna@vcs.local", When: time.Now()}
+	committer := author
+	if userAuthor, userCommitter, err := r.GetSignatures(); err == nil {
+		author, committer = *userAuthor, *userCommitter
+	}
+
+	commitHash, err := storeCommit(repo, &object.Commit{
+		Author:       author,
+		Committer:    committer,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store commit: %w", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(refName), commitHash)); err != nil {
+		return fmt.Errorf("failed to set reference %s: %w", refName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/storer"
+)
+
+// Reference namespaces luna keeps outside of the branches.
+const (
+	// DroppedRefPrefix keeps the tips of abandoned workspaces recoverable.
+	DroppedRefPrefix = "refs/luna/dropped/"
+	// ArchiveRefPrefix keeps the step commits of landed workspaces reachable.
+	ArchiveRefPrefix = "refs/luna/archive/"
+	// OpRefPrefix keeps the worktree snapshots of the operation log from being garbage
+	// collected.
+	OpRefPrefix = "refs/luna/ops/"
+)
+
+func (r *gitRepository) GetReference(ctx context.Context, refName string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ref, err := repo.Reference(plumbing.ReferenceName(refName), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get reference %s: %w", refName, err)
+	}
+
+	return ref.Hash().String(), nil
+}
+
+func (r *gitRepository) SetReference(ctx context.Context, refName, hash string) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.ReferenceName(refName), plumbing.NewHash(hash))
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return fmt.Errorf("failed to set reference %s: %w", refName, err)
+	}
+
+	return nil
+}
+
+func (r *gitRepository) RemoveReference(ctx context.Context, refName string) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if err := repo.Storer.RemoveReference(plumbing.ReferenceName(refName)); err != nil {
+		return fmt.Errorf("failed to remove reference %s: %w", refName, err)
+	}
+
+	return nil
+}
+
+func (r *gitRepository) ListReferences(ctx context.Context, prefix string) (map[string]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	iter, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list references: %w", err)
+	}
+
+	refs := make(map[string]string)
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		if ref.Type() != plumbing.HashReference || !strings.HasPrefix(ref.Name().String(), prefix) {
+			return nil
+		}
+		refs[ref.Name().String()] = ref.Hash().String()
+		return nil
+	})
+	if err != nil && err != storer.ErrStop {
+		return nil, fmt.Errorf("failed to iterate references: %w", err)
+	}
+
+	return refs, nil
+}
+
+func (r *gitRepository) DeleteBranch(ctx context.Context, branchName string) error {
+	return r.RemoveReference(ctx, plumbing.NewBranchReferenceName(branchName).String())
+}
+
+func (r *gitRepository) ResolveRevision(ctx context.Context, revision string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(revision))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", revision, err)
+	}
+
+	return hash.String(), nil
+}
@@ -0,0 +1,273 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+func (r *gitRepository) ListBranchCommits(ctx context.Context, branchName, baseBranch string) ([]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	branchRef, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch %s: %w", branchName, err)
+	}
+
+	baseRef, err := repo.Reference(plumbing.NewBranchReferenceName(baseBranch), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch %s: %w", baseBranch, err)
+	}
+
+	branchCommit, err := repo.CommitObject(branchRef.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get branch commit: %w", err)
+	}
+
+	baseCommit, err := repo.CommitObject(baseRef.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get base branch commit: %w", err)
+	}
+
+	ancestor, err := mergeBase(branchCommit, baseCommit)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []string
+	for current := branchCommit; current.Hash != ancestor.Hash; {
+		commits = append(commits, current.Hash.String())
+		if current.NumParents() == 0 {
+			break
+		}
+
+		current, err = current.Parent(0)
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk branch history: %w", err)
+		}
+	}
+
+	// Oldest first so they can be replayed in order
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+
+	return commits, nil
+}
+
+func (r *gitRepository) ReplayCommits(ctx context.Context, branchName, baseBranch, ontoHash string, commits []string) (map[string]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	_, committer, err := r.GetSignatures()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user signature: %w", err)
+	}
+
+	// Rewritten steps lose their signature, so they are signed again like new commits
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := make(map[string]string, len(commits))
+	tip := plumbing.NewHash(ontoHash)
+
+	for _, hash := range commits {
+		commit, err := repo.CommitObject(plumbing.NewHash(hash))
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to get commit %s: %w", hash, err)
+		}
+
+		// Already on top of the new base, nothing to rewrite
+		if len(commit.ParentHashes) == 1 && commit.ParentHashes[0] == tip {
+			rewritten[hash] = hash
+			tip = commit.Hash
+			continue
+		}
+
+		var parentTree *object.Tree
+		if commit.NumParents() > 0 {
+			parent, err := commit.Parent(0)
+			if err != nil {
+				return rewritten, fmt.Errorf("failed to get parent of %s: %w", hash, err)
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return rewritten, fmt.Errorf("failed to get parent tree of %s: %w", hash, err)
+			}
+		}
+
+		tipCommit, err := repo.CommitObject(tip)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to get commit %s: %w", tip, err)
+		}
+
+		tipTree, err := tipCommit.Tree()
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to get tree of %s: %w", tip, err)
+		}
+
+		commitTree, err := commit.Tree()
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to get tree of %s: %w", hash, err)
+		}
+
+		result, err := mergeTrees(repo, parentTree, tipTree, commitTree, baseBranch, shortHash(commit.Hash))
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to replay %s: %w", shortHash(commit.Hash), err)
+		}
+
+		if len(result.Conflicts) > 0 {
+			if err := r.checkoutBranchForConflict(ctx, worktree, branchName); err != nil {
+				return rewritten, err
+			}
+
+			branchRef, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), true)
+			if err != nil {
+				return rewritten, fmt.Errorf("failed to get branch %s: %w", branchName, err)
+			}
+
+			if err := r.checkoutConflictTree(repo, worktree, branchName, branchRef.Hash(), result.TreeHash); err != nil {
+				return rewritten, fmt.Errorf("failed to write conflicted files: %w", err)
+			}
+
+			return rewritten, &ConflictError{
+				Paths:      result.conflictPaths(),
+				TargetHash: tip.String(),
+				Commit:     hash,
+			}
+		}
+
+		replayed := &object.Commit{
+			Author:       commit.Author,
+			Committer:    *committer,
+			Message:      commit.Message,
+			TreeHash:     result.TreeHash,
+			ParentHashes: []plumbing.Hash{tip},
+		}
+
+		if err := signCommit(signer, replayed); err != nil {
+			return rewritten, fmt.Errorf("failed to sign replayed commit: %w", err)
+		}
+
+		tip, err = storeCommit(repo, replayed)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to store replayed commit: %w", err)
+		}
+		rewritten[hash] = tip.String()
+	}
+
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branchName), tip)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return rewritten, fmt.Errorf("failed to update branch %s: %w", branchName, err)
+	}
+
+	if currentBranch, err := r.GetCurrentBranch(ctx); err == nil && currentBranch == branchName {
+		if err := worktree.Reset(&git.ResetOptions{Commit: tip, Mode: git.HardReset}); err != nil {
+			return rewritten, fmt.Errorf("failed to update worktree: %w", err)
+		}
+	}
+
+	return rewritten, nil
+}
+
+func (r *gitRepository) CommitResolved(ctx context.Context, originalHash, parentHash string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	original, err := repo.CommitObject(plumbing.NewHash(originalHash))
+	if err != nil {
+		return "", fmt.Errorf("failed to get commit %s: %w", originalHash, err)
+	}
+
+	_, committer, err := r.GetSignatures()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user signature: %w", err)
+	}
+
+	treeHash, err := writeIndexTree(repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to write resolved tree: %w", err)
+	}
+
+	resolved := &object.Commit{
+		Author:       original.Author,
+		Committer:    *committer,
+		Message:      original.Message,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{plumbing.NewHash(parentHash)},
+	}
+
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return "", err
+	}
+	if err := signCommit(signer, resolved); err != nil {
+		return "", fmt.Errorf("failed to sign resolved commit: %w", err)
+	}
+
+	hash, err := storeCommit(repo, resolved)
+	if err != nil {
+		return "", err
+	}
+
+	return hash.String(), nil
+}
+
+func (r *gitRepository) IsClean(ctx context.Context) (bool, error) {
+	repo, err := r.open()
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	return status.IsClean(), nil
+}
+
+// checkoutBranchForConflict makes sure the branch receiving conflict markers is checked out.
+func (r *gitRepository) checkoutBranchForConflict(ctx context.Context, worktree *git.Worktree, branchName string) error {
+	currentBranch, err := r.GetCurrentBranch(ctx)
+	if err == nil && currentBranch == branchName {
+		return nil
+	}
+
+	if err := worktree.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branchName),
+	}); err != nil {
+		if errors.Is(err, git.ErrUnstagedChanges) {
+			return fmt.Errorf("cannot check out %s to resolve conflicts: worktree has uncommitted changes", branchName)
+		}
+		return fmt.Errorf("failed to checkout %s: %w", branchName, err)
+	}
+
+	return nil
+}
+
+func shortHash(hash plumbing.Hash) string {
+	return hash.String()[:7]
+}
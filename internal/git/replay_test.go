@@ -0,0 +1,110 @@
+package git
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// commitFiles writes the files in the worktree of the repository and commits them.
+func commitFiles(t *testing.T, repo Repository, dir, message string, files map[string]string) string {
+	t.Helper()
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := repo.StageAll(context.Background()); err != nil {
+		t.Fatalf("StageAll: %v", err)
+	}
+
+	hash, err := repo.Commit(context.Background(), message)
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	return hash
+}
+
+func TestReplayCommitsConflict(t *testing.T) {
+	home := isolateGitConfig(t)
+	writeGitConfig(t, home)
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := NewRepositoryFactory().NewRepository(dir)
+	if err := repo.Init(ctx, dir, "luna", "Initial commit"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	commitFiles(t, repo, dir, "Add files", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+
+	if err := repo.CreateBranch(ctx, "ws/feat", "luna"); err != nil {
+		t.Fatalf("CreateBranch: %v", err)
+	}
+	if err := repo.SwitchBranch(ctx, "ws/feat"); err != nil {
+		t.Fatalf("SwitchBranch: %v", err)
+	}
+	first := commitFiles(t, repo, dir, "Edit a", map[string]string{"a.txt": "step\n"})
+	second := commitFiles(t, repo, dir, "Edit b", map[string]string{"b.txt": "step\n"})
+	third := commitFiles(t, repo, dir, "Add c", map[string]string{"c.txt": "c\n"})
+
+	if err := repo.SwitchBranch(ctx, "luna"); err != nil {
+		t.Fatalf("SwitchBranch: %v", err)
+	}
+	onto := commitFiles(t, repo, dir, "Edit b on trunk", map[string]string{"b.txt": "trunk\n"})
+	if err := repo.SwitchBranch(ctx, "ws/feat"); err != nil {
+		t.Fatalf("SwitchBranch: %v", err)
+	}
+
+	commits, err := repo.ListBranchCommits(ctx, "ws/feat", "luna")
+	if err != nil {
+		t.Fatalf("ListBranchCommits: %v", err)
+	}
+	if want := []string{first, second, third}; strings.Join(commits, " ") != strings.Join(want, " ") {
+		t.Fatalf("ListBranchCommits = %v, want %v", commits, want)
+	}
+
+	rewritten, err := repo.ReplayCommits(ctx, "ws/feat", "luna", onto, commits)
+
+	var conflict *ConflictError
+	if !errors.As(err, &conflict) {
+		t.Fatalf("ReplayCommits error = %v, want a *ConflictError", err)
+	}
+	if conflict.Commit != second {
+		t.Errorf("conflicting commit = %s, want %s", conflict.Commit, second)
+	}
+	if len(conflict.Paths) != 1 || conflict.Paths[0] != "b.txt" {
+		t.Errorf("conflicting paths = %v, want [b.txt]", conflict.Paths)
+	}
+
+	replayed, ok := rewritten[first]
+	if len(rewritten) != 1 || !ok || replayed == first {
+		t.Fatalf("rewritten = %v, want only %s replayed onto the trunk", rewritten, first)
+	}
+	if conflict.TargetHash != replayed {
+		t.Errorf("conflict target = %s, want the replayed %s", conflict.TargetHash, replayed)
+	}
+	if info, err := repo.GetCommit(ctx, replayed); err != nil || len(info.Parents) != 1 || info.Parents[0] != onto {
+		t.Errorf("replayed commit = %+v, %v, want a child of %s", info, err, onto)
+	}
+
+	tip, err := repo.GetBranchHash(ctx, "ws/feat")
+	if err != nil {
+		t.Fatalf("GetBranchHash: %v", err)
+	}
+	if tip != third {
+		t.Errorf("branch tip = %s, want it left at %s", tip, third)
+	}
+
+	content, err := os.ReadFile(filepath.Join(dir, "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(content), "<<<<<<< luna\ntrunk\n=======\nstep\n>>>>>>> ") {
+		t.Errorf("b.txt = %q, want conflict markers", content)
+	}
+}
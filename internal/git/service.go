@@ -4,9 +4,7 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"strings"
 	"time"
 
 	"github.com/go-git/go-git/v6"
@@ -15,8 +13,9 @@ import (
 )
 
 type gitRepository struct {
-	path string
-	repo *git.Repository
+	path     string
+	repo     *git.Repository
+	identity IdentityFunc
 }
 
 type gitRepositoryFactory struct{}
@@ -31,7 +30,7 @@ func (f *gitRepositoryFactory) NewRepository(path string) Repository {
 	}
 }
 
-func (r *gitRepository) Init(ctx context.Context, path string) error {
+func (r *gitRepository) Init(ctx context.Context, path, trunk, message string) error {
 	if path == "" {
 		var err error
 		path, err = os.Getwd()
@@ -75,7 +74,8 @@ func (r *gitRepository) Init(ctx context.Context, path string) error {
 
 	gitignorePath := filepath.Join(absPath, ".gitignore")
 	gitignoreContent := `# Luna files
-.luna/
+.luna/*
+!.luna/config.toml
 
 # Common files to ignore
 *.log
@@ -93,40 +93,54 @@ Thumbs.db
 		return fmt.Errorf("failed to add files to staging: %w", err)
 	}
 
-	signature := &object.Signature{
-		Name:  "Luna",
-		Email: "luna@vcs.local",
+	// Without any identity configured, the project still gets its first commit
+	author := &object.Signature{Name: "Luna", Email: "luna@vcs.local", When: time.Now()}
+	committer := author
+	if userAuthor, userCommitter, err := r.GetSignatures(); err == nil {
+		author, committer = userAuthor, userCommitter
 	}
 
-	_, err = worktree.Commit("Initial commit", &git.CommitOptions{
-		Author: signature,
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return err
+	}
+
+	_, err = worktree.Commit(message, &git.CommitOptions{
+		Author:    author,
+		Committer: committer,
+		Signer:    signer,
 	})
 	if err != nil {
 		return fmt.Errorf("failed to create initial commit: %w", err)
 	}
 
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	if head.Name() == plumbing.NewBranchReferenceName(trunk) {
+		return nil
+	}
+
 	err = worktree.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName("luna"),
+		Branch: plumbing.NewBranchReferenceName(trunk),
 		Create: true,
 	})
 	if err != nil {
-		return fmt.Errorf("failed to checkout luna branch: %w", err)
+		return fmt.Errorf("failed to checkout %s branch: %w", trunk, err)
 	}
 
 	return nil
 }
 
 func (r *gitRepository) IsRepository(path string) (bool, error) {
-	gitDir := filepath.Join(path, ".git")
-
-	if _, err := os.Stat(gitDir); os.IsNotExist(err) {
+	if _, _, err := ResolveGitDirs(path); os.IsNotExist(err) {
 		return false, nil
 	} else if err != nil {
 		return false, fmt.Errorf("failed to check .git directory: %w", err)
 	}
 
-	_, err := git.PlainOpen(path)
-	if err != nil {
+	if _, err := (&gitRepository{path: path}).open(); err != nil {
 		return false, nil
 	}
 
@@ -138,7 +152,7 @@ func (r *gitRepository) GetPath() string {
 }
 
 func (r *gitRepository) CreateBranch(ctx context.Context, branchName, baseBranch string) error {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -159,7 +173,7 @@ func (r *gitRepository) CreateBranch(ctx context.Context, branchName, baseBranch
 }
 
 func (r *gitRepository) SwitchBranch(ctx context.Context, branchName string) error {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -169,10 +183,19 @@ func (r *gitRepository) SwitchBranch(ctx context.Context, branchName string) err
 		return fmt.Errorf("failed to get worktree: %w", err)
 	}
 
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
+
 	err = worktree.Checkout(&git.CheckoutOptions{
 		Branch: plumbing.NewBranchReferenceName(branchName),
 	})
 	if err != nil {
+		// go-git moves HEAD before refusing to overwrite changes, put it back
+		if restoreErr := repo.Storer.SetReference(head); restoreErr != nil {
+			return fmt.Errorf("failed to checkout branch: %w (and failed to restore HEAD: %v)", err, restoreErr)
+		}
 		return fmt.Errorf("failed to checkout branch: %w", err)
 	}
 
@@ -180,7 +203,7 @@ func (r *gitRepository) SwitchBranch(ctx context.Context, branchName string) err
 }
 
 func (r *gitRepository) StageAll(ctx context.Context) error {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -199,7 +222,7 @@ func (r *gitRepository) StageAll(ctx context.Context) error {
 }
 
 func (r *gitRepository) Commit(ctx context.Context, message string) (string, error) {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return "", fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -209,13 +232,20 @@ func (r *gitRepository) Commit(ctx context.Context, message string) (string, err
 		return "", fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	signature, err := r.GetUserSignature()
+	author, committer, err := r.GetSignatures()
 	if err != nil {
 		return "", fmt.Errorf("failed to get user signature: %w", err)
 	}
 
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return "", err
+	}
+
 	commit, err := worktree.Commit(message, &git.CommitOptions{
-		Author: signature,
+		Author:    author,
+		Committer: committer,
+		Signer:    signer,
 	})
 	if err != nil {
 		return "", fmt.Errorf("failed to commit: %w", err)
@@ -225,7 +255,7 @@ func (r *gitRepository) Commit(ctx context.Context, message string) (string, err
 }
 
 func (r *gitRepository) GetCurrentBranch(ctx context.Context) (string, error) {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return "", fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -242,84 +272,306 @@ func (r *gitRepository) GetCurrentBranch(ctx context.Context) (string, error) {
 	return head.Name().Short(), nil
 }
 
-func (r *gitRepository) SquashAndRebase(ctx context.Context, baseBranch, commitMessage string) error {
-	repo, err := git.PlainOpen(r.path)
+func (r *gitRepository) SquashAndRebase(ctx context.Context, baseBranch, commitMessage string) (string, error) {
+	repo, err := r.open()
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return "", fmt.Errorf("failed to open repository: %w", err)
 	}
 
 	worktree, err := repo.Worktree()
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+		return "", fmt.Errorf("failed to get worktree: %w", err)
 	}
 
 	currentBranch, err := r.GetCurrentBranch(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
+		return "", fmt.Errorf("failed to get current branch: %w", err)
 	}
 
 	if currentBranch == baseBranch {
-		return fmt.Errorf("already on base branch %s", baseBranch)
+		return "", fmt.Errorf("already on base branch %s", baseBranch)
 	}
 
 	baseBranchRef, err := repo.Reference(plumbing.NewBranchReferenceName(baseBranch), true)
 	if err != nil {
-		return fmt.Errorf("failed to get base branch reference: %w", err)
+		return "", fmt.Errorf("failed to get base branch reference: %w", err)
 	}
 
 	workspaceBranchRef, err := repo.Reference(plumbing.NewBranchReferenceName(currentBranch), true)
 	if err != nil {
-		return fmt.Errorf("failed to get workspace branch reference: %w", err)
+		return "", fmt.Errorf("failed to get workspace branch reference: %w", err)
 	}
 
 	workspaceCommitObj, err := repo.CommitObject(workspaceBranchRef.Hash())
 	if err != nil {
-		return fmt.Errorf("failed to get workspace commit: %w", err)
+		return "", fmt.Errorf("failed to get workspace commit: %w", err)
 	}
 
-	signature, err := r.GetUserSignature()
+	baseCommitObj, err := repo.CommitObject(baseBranchRef.Hash())
 	if err != nil {
-		return fmt.Errorf("failed to get user signature: %w", err)
+		return "", fmt.Errorf("failed to get base branch commit: %w", err)
+	}
+
+	ancestor, err := mergeBase(workspaceCommitObj, baseCommitObj)
+	if err != nil {
+		return "", err
+	}
+
+	treeHash := workspaceCommitObj.TreeHash
+
+	// The base branch moved since the workspace was created, replay the workspace changes on top of it
+	if ancestor.Hash != baseCommitObj.Hash {
+		ancestorTree, err := ancestor.Tree()
+		if err != nil {
+			return "", fmt.Errorf("failed to get merge base tree: %w", err)
+		}
+
+		baseTree, err := baseCommitObj.Tree()
+		if err != nil {
+			return "", fmt.Errorf("failed to get base branch tree: %w", err)
+		}
+
+		workspaceTree, err := workspaceCommitObj.Tree()
+		if err != nil {
+			return "", fmt.Errorf("failed to get workspace tree: %w", err)
+		}
+
+		result, err := mergeTrees(repo, ancestorTree, baseTree, workspaceTree, baseBranch, currentBranch)
+		if err != nil {
+			return "", fmt.Errorf("failed to merge workspace onto %s: %w", baseBranch, err)
+		}
+
+		if len(result.Conflicts) > 0 {
+			if err := r.checkoutConflictTree(repo, worktree, currentBranch, workspaceCommitObj.Hash, result.TreeHash); err != nil {
+				return "", fmt.Errorf("failed to write conflicted files: %w", err)
+			}
+
+			return "", &ConflictError{
+				Paths:      result.conflictPaths(),
+				TargetHash: baseCommitObj.Hash.String(),
+			}
+		}
+
+		treeHash = result.TreeHash
+	}
+
+	return r.landSquashedCommit(repo, worktree, baseBranch, currentBranch, baseBranchRef.Hash(), treeHash, commitMessage)
+}
+
+func (r *gitRepository) ContinueSquashAndRebase(ctx context.Context, baseBranch, baseHash, commitMessage string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	currentBranch, err := r.GetCurrentBranch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	if currentBranch == baseBranch {
+		return "", fmt.Errorf("already on base branch %s", baseBranch)
+	}
+
+	baseBranchRef, err := repo.Reference(plumbing.NewBranchReferenceName(baseBranch), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get base branch reference: %w", err)
+	}
+
+	if baseBranchRef.Hash().String() != baseHash {
+		return "", fmt.Errorf("branch %s moved since the merge started, abort and finish again", baseBranch)
+	}
+
+	treeHash, err := writeIndexTree(repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to write resolved tree: %w", err)
+	}
+
+	return r.landSquashedCommit(repo, worktree, baseBranch, currentBranch, baseBranchRef.Hash(), treeHash, commitMessage)
+}
+
+// landSquashedCommit commits treeHash on top of parent, moves baseBranch to it and deletes the workspace branch.
+func (r *gitRepository) landSquashedCommit(repo *git.Repository, worktree *git.Worktree, baseBranch, workspaceBranch string, parent, treeHash plumbing.Hash, commitMessage string) (string, error) {
+	author, committer, err := r.GetSignatures()
+	if err != nil {
+		return "", fmt.Errorf("failed to get user signature: %w", err)
+	}
+
+	// Other worktrees on baseBranch follow it, which would lose their uncommitted changes
+	others, err := r.otherWorktreesOn(context.Background(), baseBranch)
+	if err != nil {
+		return "", err
+	}
+	for _, other := range others {
+		clean, err := (&gitRepository{path: other.Path}).IsClean(context.Background())
+		if err != nil {
+			return "", fmt.Errorf("failed to check worktree %s: %w", other.Path, err)
+		}
+		if !clean {
+			return "", fmt.Errorf("%s is checked out with uncommitted changes in %s", baseBranch, other.Path)
+		}
 	}
 
 	squashedCommit := &object.Commit{
-		Author:       *signature,
-		Committer:    *signature,
+		Author:       *author,
+		Committer:    *committer,
 		Message:      commitMessage,
-		TreeHash:     workspaceCommitObj.TreeHash,
-		ParentHashes: []plumbing.Hash{baseBranchRef.Hash()},
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{parent},
 	}
 
-	obj := repo.Storer.NewEncodedObject()
-	if err := squashedCommit.Encode(obj); err != nil {
-		return fmt.Errorf("failed to encode squashed commit: %w", err)
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return "", err
+	}
+	if err := signCommit(signer, squashedCommit); err != nil {
+		return "", fmt.Errorf("failed to sign squashed commit: %w", err)
 	}
 
-	commitHash, err := repo.Storer.SetEncodedObject(obj)
+	commitHash, err := storeCommit(repo, squashedCommit)
 	if err != nil {
-		return fmt.Errorf("failed to store squashed commit: %w", err)
+		return "", fmt.Errorf("failed to store squashed commit: %w", err)
 	}
 
 	newRef := plumbing.NewHashReference(plumbing.NewBranchReferenceName(baseBranch), commitHash)
 	if err := repo.Storer.SetReference(newRef); err != nil {
-		return fmt.Errorf("failed to update base branch reference: %w", err)
+		return "", fmt.Errorf("failed to update base branch reference: %w", err)
+	}
+
+	// A branch can only be checked out in one worktree, when another one has the base
+	// branch this one is left detached on the landed commit
+	checkout := &git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(baseBranch)}
+	if len(others) > 0 {
+		checkout = &git.CheckoutOptions{Hash: commitHash}
+	}
+	if err := worktree.Checkout(checkout); err != nil {
+		return "", fmt.Errorf("failed to checkout base branch: %w", err)
+	}
+
+	for _, other := range others {
+		if err := (&gitRepository{path: other.Path}).resetHard(commitHash); err != nil {
+			return "", fmt.Errorf("failed to update worktree %s: %w", other.Path, err)
+		}
+	}
+
+	if err := repo.Storer.RemoveReference(plumbing.NewBranchReferenceName(workspaceBranch)); err != nil {
+		return "", fmt.Errorf("failed to delete workspace branch: %w", err)
 	}
 
-	if err := worktree.Checkout(&git.CheckoutOptions{
-		Branch: plumbing.NewBranchReferenceName(baseBranch),
+	return commitHash.String(), nil
+}
+
+// checkoutConflictTree writes a conflicted merge tree into the worktree while keeping the branch at tip.
+func (r *gitRepository) checkoutConflictTree(repo *git.Repository, worktree *git.Worktree, branch string, tip, treeHash plumbing.Hash) error {
+	author, committer, err := r.GetSignatures()
+	if err != nil {
+		return fmt.Errorf("failed to get user signature: %w", err)
+	}
+
+	conflictCommit := &object.Commit{
+		Author:       *author,
+		Committer:    *committer,
+		Message:      "luna: conflicted merge",
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{tip},
+	}
+
+	commitHash, err := storeCommit(repo, conflictCommit)
+	if err != nil {
+		return err
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: commitHash, Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to reset worktree: %w", err)
+	}
+
+	ref := plumbing.NewHashReference(plumbing.NewBranchReferenceName(branch), tip)
+	if err := repo.Storer.SetReference(ref); err != nil {
+		return fmt.Errorf("failed to restore branch reference: %w", err)
+	}
+
+	return nil
+}
+
+func (r *gitRepository) RestoreBranch(ctx context.Context, branchName, tipHash, worktreeHash string) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	currentBranch, err := r.GetCurrentBranch(ctx)
+	if err != nil || currentBranch != branchName {
+		if err := worktree.Checkout(&git.CheckoutOptions{
+			Branch: plumbing.NewBranchReferenceName(branchName),
+			Force:  true,
+		}); err != nil {
+			return fmt.Errorf("failed to checkout branch %s: %w", branchName, err)
+		}
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{
+		Commit: plumbing.NewHash(worktreeHash),
+		Mode:   git.HardReset,
 	}); err != nil {
-		return fmt.Errorf("failed to checkout base branch: %w", err)
+		return fmt.Errorf("failed to restore worktree: %w", err)
+	}
+
+	if worktreeHash != tipHash {
+		if err := worktree.Reset(&git.ResetOptions{
+			Commit: plumbing.NewHash(tipHash),
+			Mode:   git.MixedReset,
+		}); err != nil {
+			return fmt.Errorf("failed to restore branch tip: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *gitRepository) DetachHead(ctx context.Context, hash string) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
 	}
 
-	if err := repo.Storer.RemoveReference(plumbing.NewBranchReferenceName(currentBranch)); err != nil {
-		return fmt.Errorf("failed to delete workspace branch: %w", err)
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(hash), Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", hash, err)
 	}
 
 	return nil
 }
 
+func (r *gitRepository) GetBranchHash(ctx context.Context, branchName string) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return "", fmt.Errorf("failed to get branch %s: %w", branchName, err)
+	}
+
+	return ref.Hash().String(), nil
+}
+
 func (r *gitRepository) HasStagedChanges(ctx context.Context) (bool, error) {
-	repo, err := git.PlainOpen(r.path)
+	repo, err := r.open()
 	if err != nil {
 		return false, fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -344,32 +596,96 @@ func (r *gitRepository) HasStagedChanges(ctx context.Context) (bool, error) {
 	return false, nil
 }
 
-func (r *gitRepository) GetUserSignature() (*object.Signature, error) {
-	// Get user name from git config
-	nameCmd := exec.Command("git", "config", "--global", "user.name")
-	nameOutput, err := nameCmd.Output()
+func storeCommit(repo *git.Repository, commit *object.Commit) (plumbing.Hash, error) {
+	obj := repo.Storer.NewEncodedObject()
+	if err := commit.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to encode commit: %w", err)
+	}
+
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store commit: %w", err)
+	}
+
+	return hash, nil
+}
+
+func (r *gitRepository) ListBranches(ctx context.Context) ([]string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	iter, err := repo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	var branches []string
+	err = iter.ForEach(func(ref *plumbing.Reference) error {
+		branches = append(branches, ref.Name().Short())
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to iterate branches: %w", err)
+	}
+
+	return branches, nil
+}
+
+func (r *gitRepository) AheadBehind(ctx context.Context, branchName, baseBranch string) (int, int, error) {
+	repo, err := r.open()
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	branchRef, err := repo.Reference(plumbing.NewBranchReferenceName(branchName), true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get branch %s: %w", branchName, err)
+	}
+
+	baseRef, err := repo.Reference(plumbing.NewBranchReferenceName(baseBranch), true)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get branch %s: %w", baseBranch, err)
+	}
+
+	branchCommit, err := repo.CommitObject(branchRef.Hash())
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get branch commit: %w", err)
+	}
+
+	baseCommit, err := repo.CommitObject(baseRef.Hash())
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user.name from git config: %w", err)
+		return 0, 0, fmt.Errorf("failed to get base branch commit: %w", err)
 	}
-	name := strings.TrimSpace(string(nameOutput))
-	if name == "" {
-		return nil, fmt.Errorf("user.name is not set in git config")
+
+	ancestor, err := mergeBase(branchCommit, baseCommit)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	// Get user email from git config
-	emailCmd := exec.Command("git", "config", "--global", "user.email")
-	emailOutput, err := emailCmd.Output()
+	ahead, err := countCommitsUntil(branchCommit, ancestor.Hash)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get user.email from git config: %w", err)
+		return 0, 0, err
 	}
-	email := strings.TrimSpace(string(emailOutput))
-	if email == "" {
-		return nil, fmt.Errorf("user.email is not set in git config")
+
+	behind, err := countCommitsUntil(baseCommit, ancestor.Hash)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return &object.Signature{
-		Name:  name,
-		Email: email,
-		When:  time.Now(),
-	}, nil
+	return ahead, behind, nil
+}
+
+// countCommitsUntil counts first-parent commits from tip down to, but excluding, stop.
+func countCommitsUntil(tip *object.Commit, stop plumbing.Hash) (int, error) {
+	count := 0
+	for current := tip; current.Hash != stop && current.NumParents() > 0; count++ {
+		var err error
+		current, err = current.Parent(0)
+		if err != nil {
+			return 0, fmt.Errorf("failed to walk history: %w", err)
+		}
+	}
+	return count, nil
 }
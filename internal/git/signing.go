@@ -0,0 +1,378 @@
+package git
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+const (
+	SignatureFormatOpenPGP = "openpgp"
+	SignatureFormatSSH     = "ssh"
+)
+
+// sshNamespace is the namespace git signs commits in with ssh keys.
+const sshNamespace = "git"
+
+// SignatureCheck is the outcome of verifying the signature of a commit.
+type SignatureCheck struct {
+	// Format is openpgp or ssh, "" when the commit is not signed.
+	Format string
+	// Good is set when the signature is valid and made by a known key.
+	Good bool
+	// Signer is who made the signature: the key id and user id for openpgp, the
+	// principal for ssh.
+	Signer string
+	// Reason explains why the signature is not good.
+	Reason string
+}
+
+// commandSigner signs commits by running gpg or ssh-keygen, the programs git uses, so
+// keys held by an agent or a hardware token work as they do with git.
+type commandSigner struct {
+	format  string
+	program string
+	key     string
+}
+
+// commitSigner returns the signer of new commits when commit.gpgsign is set, nil otherwise.
+// Like git, openpgp signs with the key of the committer unless user.signingkey is set.
+func (r *gitRepository) commitSigner(committer *object.Signature) (git.Signer, error) {
+	values, err := r.gitConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if !gitConfigBool(values["commit.gpgsign"]) {
+		return nil, nil
+	}
+
+	switch format := firstNonEmpty(values["gpg.format"], SignatureFormatOpenPGP); format {
+	case SignatureFormatOpenPGP:
+		return &commandSigner{
+			format:  format,
+			program: firstNonEmpty(values["gpg.openpgp.program"], values["gpg.program"], "gpg"),
+			key:     firstNonEmpty(values["user.signingkey"], fmt.Sprintf("%s <%s>", committer.Name, committer.Email)),
+		}, nil
+	case SignatureFormatSSH:
+		if values["user.signingkey"] == "" {
+			return nil, fmt.Errorf("commit.gpgsign is set but user.signingkey is not - set it to your ssh key")
+		}
+		return &commandSigner{
+			format:  format,
+			program: firstNonEmpty(values["gpg.ssh.program"], "ssh-keygen"),
+			key:     values["user.signingkey"],
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported gpg.format '%s' (expected openpgp or ssh)", format)
+	}
+}
+
+func (s *commandSigner) Sign(message io.Reader) ([]byte, error) {
+	if s.format == SignatureFormatSSH {
+		return s.signSSH(message)
+	}
+	return s.signOpenPGP(message)
+}
+
+func (s *commandSigner) signOpenPGP(message io.Reader) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(s.program, "--status-fd=2", "-bsau", s.key)
+	command.Stdin = message
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	err := command.Run()
+	if err != nil || !strings.Contains(stderr.String(), "[GNUPG:] SIG_CREATED ") {
+		return nil, fmt.Errorf("gpg failed to sign the commit: %s", commandOutput(stderr, err))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+func (s *commandSigner) signSSH(message io.Reader) ([]byte, error) {
+	args := []string{"-Y", "sign", "-n", sshNamespace}
+
+	// A literal public key is signed with by the ssh agent holding its private key
+	if literal, ok := literalSSHKey(s.key); ok {
+		keyFile, err := writeTempFile("luna-signing-key-*", []byte(literal+"\n"))
+		if err != nil {
+			return nil, err
+		}
+		defer os.Remove(keyFile)
+		args = append(args, "-f", keyFile, "-U")
+	} else {
+		args = append(args, "-f", expandHome(s.key))
+	}
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(s.program, args...)
+	command.Stdin = message
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	if err := command.Run(); err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed to sign the commit: %s", commandOutput(stderr, err))
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// signCommit signs a commit built by hand the way worktree.Commit does with a signer.
+func signCommit(signer git.Signer, commit *object.Commit) error {
+	if signer == nil {
+		return nil
+	}
+
+	payload, err := commitPayload(commit)
+	if err != nil {
+		return err
+	}
+
+	signature, err := signer.Sign(bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+
+	commit.PGPSignature = string(signature)
+	return nil
+}
+
+// commitPayload is the part of a commit its signature covers: everything but the signature.
+func commitPayload(commit *object.Commit) ([]byte, error) {
+	encoded := &plumbing.MemoryObject{}
+	if err := commit.EncodeWithoutSignature(encoded); err != nil {
+		return nil, fmt.Errorf("failed to encode commit: %w", err)
+	}
+
+	reader, err := encoded.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read encoded commit: %w", err)
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+func (r *gitRepository) SignsCommits() (bool, error) {
+	_, committer, err := r.GetSignatures()
+	if err != nil {
+		return false, err
+	}
+
+	signer, err := r.commitSigner(committer)
+	if err != nil {
+		return false, err
+	}
+	return signer != nil, nil
+}
+
+func (r *gitRepository) VerifyCommit(ctx context.Context, hash string) (*SignatureCheck, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	commit, err := repo.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+	}
+
+	if commit.PGPSignature == "" {
+		return &SignatureCheck{Reason: "not signed"}, nil
+	}
+
+	payload, err := commitPayload(commit)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := r.gitConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	signature := strings.TrimSpace(commit.PGPSignature)
+	switch {
+	case strings.HasPrefix(signature, "-----BEGIN PGP SIGNATURE-----"):
+		program := firstNonEmpty(values["gpg.openpgp.program"], values["gpg.program"], "gpg")
+		return verifyOpenPGP(program, commit.PGPSignature, payload)
+	case strings.HasPrefix(signature, "-----BEGIN SSH SIGNATURE-----"):
+		program := firstNonEmpty(values["gpg.ssh.program"], "ssh-keygen")
+		return verifySSH(program, values["gpg.ssh.allowedsignersfile"], commit.PGPSignature, payload)
+	}
+
+	return &SignatureCheck{Reason: "unsupported signature format"}, nil
+}
+
+// verifyOpenPGP checks a signature against the gpg keyring, reading the outcome from the
+// status lines of gpg as git does.
+func verifyOpenPGP(program, signature string, payload []byte) (*SignatureCheck, error) {
+	signatureFile, err := writeTempFile("luna-signature-*", []byte(signature))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(signatureFile)
+
+	var stdout, stderr bytes.Buffer
+	command := exec.Command(program, "--status-fd=1", "--verify", signatureFile, "-")
+	command.Stdin = bytes.NewReader(payload)
+	command.Stdout = &stdout
+	command.Stderr = &stderr
+
+	runErr := command.Run()
+	if _, isExit := runErr.(*exec.ExitError); runErr != nil && !isExit {
+		return nil, fmt.Errorf("failed to run %s: %w", program, runErr)
+	}
+
+	check := &SignatureCheck{Format: SignatureFormatOpenPGP}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		status, ok := strings.CutPrefix(scanner.Text(), "[GNUPG:] ")
+		if !ok {
+			continue
+		}
+
+		keyword, rest, _ := strings.Cut(status, " ")
+		keyID, userID, _ := strings.Cut(rest, " ")
+		switch keyword {
+		case "GOODSIG":
+			check.Good = runErr == nil
+			check.Signer = fmt.Sprintf("%s (key %s)", userID, keyID)
+		case "BADSIG":
+			check.Signer = fmt.Sprintf("%s (key %s)", userID, keyID)
+			check.Reason = "bad signature"
+		case "EXPSIG", "EXPKEYSIG":
+			check.Signer = fmt.Sprintf("%s (key %s)", userID, keyID)
+			check.Reason = "expired key"
+		case "REVKEYSIG":
+			check.Signer = fmt.Sprintf("%s (key %s)", userID, keyID)
+			check.Reason = "revoked key"
+		case "ERRSIG":
+			check.Signer = fmt.Sprintf("key %s", keyID)
+			check.Reason = "no public key to check it with"
+		}
+	}
+
+	if check.Good {
+		check.Reason = ""
+	} else if check.Reason == "" {
+		check.Reason = commandOutput(stderr, runErr)
+	}
+
+	return check, nil
+}
+
+// verifySSH checks a signature against the allowed signers file, which maps the principals
+// trusted to sign commits to their keys.
+func verifySSH(program, allowedSigners, signature string, payload []byte) (*SignatureCheck, error) {
+	check := &SignatureCheck{Format: SignatureFormatSSH}
+
+	if allowedSigners == "" {
+		check.Reason = "gpg.ssh.allowedSignersFile is not set, so ssh signatures cannot be checked"
+		return check, nil
+	}
+	allowedSigners = expandHome(allowedSigners)
+
+	signatureFile, err := writeTempFile("luna-signature-*", []byte(signature))
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(signatureFile)
+
+	var principals, stderr bytes.Buffer
+	find := exec.Command(program, "-Y", "find-principals", "-f", allowedSigners, "-s", signatureFile)
+	find.Stdout = &principals
+	find.Stderr = &stderr
+	if err := find.Run(); err != nil {
+		if _, isExit := err.(*exec.ExitError); !isExit {
+			return nil, fmt.Errorf("failed to run %s: %w", program, err)
+		}
+		check.Reason = fmt.Sprintf("key not in %s", allowedSigners)
+		return check, nil
+	}
+
+	for _, principal := range strings.Fields(principals.String()) {
+		var output bytes.Buffer
+		verify := exec.Command(program, "-Y", "verify", "-f", allowedSigners, "-I", principal, "-n", sshNamespace, "-s", signatureFile)
+		verify.Stdin = bytes.NewReader(payload)
+		verify.Stdout = &output
+		verify.Stderr = &output
+
+		err := verify.Run()
+		if err == nil {
+			check.Good = true
+			check.Signer = principal
+			check.Reason = ""
+			return check, nil
+		}
+		if _, isExit := err.(*exec.ExitError); !isExit {
+			return nil, fmt.Errorf("failed to run %s: %w", program, err)
+		}
+		check.Signer = principal
+		check.Reason = commandOutput(output, err)
+	}
+
+	if check.Reason == "" {
+		check.Reason = fmt.Sprintf("key not in %s", allowedSigners)
+	}
+	return check, nil
+}
+
+// literalSSHKey returns the public key user.signingkey holds itself rather than naming a
+// file: "key::ssh-ed25519 AAAA..." or, as older git accepts, "ssh-ed25519 AAAA...".
+func literalSSHKey(key string) (string, bool) {
+	if literal, ok := strings.CutPrefix(key, "key::"); ok {
+		return literal, true
+	}
+	return key, strings.HasPrefix(key, "ssh-")
+}
+
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
+
+func writeTempFile(pattern string, data []byte) (string, error) {
+	f, err := os.CreateTemp("", pattern)
+	if err != nil {
+		return "", fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	return f.Name(), nil
+}
+
+// commandOutput is the last line a failed program printed, its exit status when it printed nothing.
+func commandOutput(output bytes.Buffer, err error) string {
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	for i := len(lines) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(lines[i]); line != "" && !strings.HasPrefix(line, "[GNUPG:]") {
+			return line
+		}
+	}
+	if err != nil {
+		return err.Error()
+	}
+	return "unknown error"
+}
@@ -0,0 +1,249 @@
+package git
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v6/plumbing"
+)
+
+const (
+	testSignerName  = "Luna Test"
+	testSignerEmail = "luna-test@example.com"
+)
+
+// isolateGitConfig points git and gpg at files of the test only and returns the home
+// directory they live in.
+func isolateGitConfig(t *testing.T) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(home, ".gitconfig"))
+	t.Setenv("GNUPGHOME", filepath.Join(home, ".gnupg"))
+	for _, name := range []string{"GIT_AUTHOR_NAME", "GIT_AUTHOR_EMAIL", "GIT_COMMITTER_NAME", "GIT_COMMITTER_EMAIL"} {
+		t.Setenv(name, "")
+	}
+
+	return home
+}
+
+func writeGitConfig(t *testing.T, home string, lines ...string) {
+	t.Helper()
+
+	content := "[user]\n\tname = " + testSignerName + "\n\temail = " + testSignerEmail + "\n" + strings.Join(lines, "\n") + "\n"
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func requireProgram(t *testing.T, name string) {
+	t.Helper()
+
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+}
+
+func run(t *testing.T, name string, args ...string) {
+	t.Helper()
+
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		t.Fatalf("%s %s: %v\n%s", name, strings.Join(args, " "), err, output)
+	}
+}
+
+// generateSSHKey creates an ed25519 key without a passphrase and returns the path of its
+// public half.
+func generateSSHKey(t *testing.T, dir, name string) string {
+	t.Helper()
+
+	key := filepath.Join(dir, name)
+	run(t, "ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", testSignerEmail, "-f", key)
+	return key + ".pub"
+}
+
+// allowSSHSigner writes an allowed signers file trusting the public key for the test signer.
+func allowSSHSigner(t *testing.T, dir, publicKey string) string {
+	t.Helper()
+
+	key, err := os.ReadFile(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	allowedSigners := filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(allowedSigners, []byte(testSignerEmail+" "+string(key)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return allowedSigners
+}
+
+// generateGPGKey creates a signing key without a passphrase for the test signer in the
+// keyring of the test.
+func generateGPGKey(t *testing.T) {
+	t.Helper()
+
+	if err := os.MkdirAll(os.Getenv("GNUPGHOME"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+	})
+
+	run(t, "gpg", "--batch", "--passphrase", "", "--quick-generate-key",
+		testSignerName+" <"+testSignerEmail+">", "ed25519", "sign", "never")
+}
+
+// commitFile initializes a repository in a new directory and commits a file on top of
+// the initial commit.
+func commitFile(t *testing.T) (Repository, string) {
+	t.Helper()
+
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	repo := NewRepositoryFactory().NewRepository(dir)
+	if err := repo.Init(ctx, dir, "luna", "Initial commit"); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte("signed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.StageAll(ctx); err != nil {
+		t.Fatalf("StageAll: %v", err)
+	}
+
+	hash, err := repo.Commit(ctx, "Add file")
+	if err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	return repo, hash
+}
+
+// tamper stores a copy of the commit with another message and the original signature.
+func tamper(t *testing.T, repo Repository, hash string) string {
+	t.Helper()
+
+	r, err := repo.(*gitRepository).open()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commit, err := r.CommitObject(plumbing.NewHash(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if commit.PGPSignature == "" {
+		t.Fatalf("commit %s is not signed", hash)
+	}
+
+	commit.Message = "Add something else"
+	tampered, err := storeCommit(r, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tampered.String()
+}
+
+func verify(t *testing.T, repo Repository, hash string) *SignatureCheck {
+	t.Helper()
+
+	check, err := repo.VerifyCommit(context.Background(), hash)
+	if err != nil {
+		t.Fatalf("VerifyCommit: %v", err)
+	}
+	return check
+}
+
+func TestVerifyCommitSSH(t *testing.T) {
+	requireProgram(t, "ssh-keygen")
+
+	home := isolateGitConfig(t)
+	publicKey := generateSSHKey(t, home, "id_ed25519")
+	allowedSigners := allowSSHSigner(t, home, publicKey)
+	writeGitConfig(t, home,
+		"[commit]", "\tgpgsign = true",
+		"[gpg]", "\tformat = ssh",
+		`[gpg "ssh"]`, "\tallowedSignersFile = "+allowedSigners,
+		"[user]", "\tsigningkey = "+publicKey,
+	)
+
+	repo, hash := commitFile(t)
+
+	t.Run("signed", func(t *testing.T) {
+		check := verify(t, repo, hash)
+		if !check.Good || check.Format != SignatureFormatSSH || check.Signer != testSignerEmail {
+			t.Fatalf("got %+v, want a good ssh signature by %s", check, testSignerEmail)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		check := verify(t, repo, tamper(t, repo, hash))
+		if check.Good {
+			t.Fatalf("tampered commit verified: %+v", check)
+		}
+		if check.Format != SignatureFormatSSH || check.Reason == "" {
+			t.Fatalf("got %+v, want a bad ssh signature with a reason", check)
+		}
+	})
+
+	t.Run("untrusted key", func(t *testing.T) {
+		other := generateSSHKey(t, home, "id_other")
+		allowSSHSigner(t, home, other)
+
+		check := verify(t, repo, hash)
+		if check.Good {
+			t.Fatalf("commit signed by a key missing from the allowed signers verified: %+v", check)
+		}
+	})
+}
+
+func TestVerifyCommitOpenPGP(t *testing.T) {
+	requireProgram(t, "gpg")
+	requireProgram(t, "gpgconf")
+
+	home := isolateGitConfig(t)
+	generateGPGKey(t)
+	writeGitConfig(t, home, "[commit]", "\tgpgsign = true")
+
+	repo, hash := commitFile(t)
+
+	t.Run("signed", func(t *testing.T) {
+		check := verify(t, repo, hash)
+		if !check.Good || check.Format != SignatureFormatOpenPGP || !strings.Contains(check.Signer, testSignerEmail) {
+			t.Fatalf("got %+v, want a good openpgp signature by %s", check, testSignerEmail)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		check := verify(t, repo, tamper(t, repo, hash))
+		if check.Good {
+			t.Fatalf("tampered commit verified: %+v", check)
+		}
+		if check.Format != SignatureFormatOpenPGP || check.Reason != "bad signature" {
+			t.Fatalf("got %+v, want a bad openpgp signature", check)
+		}
+	})
+}
+
+func TestVerifyCommitUnsigned(t *testing.T) {
+	home := isolateGitConfig(t)
+	writeGitConfig(t, home)
+
+	repo, hash := commitFile(t)
+
+	check := verify(t, repo, hash)
+	if check.Good || check.Format != "" {
+		t.Fatalf("got %+v, want an unsigned commit", check)
+	}
+}
@@ -0,0 +1,89 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/go-git/go-git/v6"
+)
+
+// FileChange is a changed path and a short description of the change.
+type FileChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+}
+
+// WorktreeStatus groups the changes of the worktree against HEAD.
+type WorktreeStatus struct {
+	Staged    []FileChange `json:"staged"`
+	Unstaged  []FileChange `json:"unstaged"`
+	Untracked []string     `json:"untracked"`
+}
+
+// IsClean reports whether there is nothing to commit.
+func (s *WorktreeStatus) IsClean() bool {
+	return len(s.Staged) == 0 && len(s.Unstaged) == 0 && len(s.Untracked) == 0
+}
+
+func (r *gitRepository) Status(ctx context.Context) (*WorktreeStatus, error) {
+	repo, err := r.open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	paths := make([]string, 0, len(status))
+	for path := range status {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	result := &WorktreeStatus{}
+	for _, path := range paths {
+		fileStatus := status[path]
+
+		if fileStatus.Worktree == git.Untracked {
+			result.Untracked = append(result.Untracked, path)
+			continue
+		}
+
+		if fileStatus.Staging != git.Unmodified {
+			result.Staged = append(result.Staged, FileChange{Path: path, Change: describeStatusCode(fileStatus.Staging)})
+		}
+
+		if fileStatus.Worktree != git.Unmodified {
+			result.Unstaged = append(result.Unstaged, FileChange{Path: path, Change: describeStatusCode(fileStatus.Worktree)})
+		}
+	}
+
+	return result, nil
+}
+
+func describeStatusCode(code git.StatusCode) string {
+	switch code {
+	case git.Added:
+		return "added"
+	case git.Modified:
+		return "modified"
+	case git.Deleted:
+		return "deleted"
+	case git.Renamed:
+		return "renamed"
+	case git.Copied:
+		return "copied"
+	case git.UpdatedButUnmerged:
+		return "unmerged"
+	default:
+		return "changed"
+	}
+}
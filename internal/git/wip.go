@@ -0,0 +1,282 @@
+package git
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/object"
+)
+
+// WIPRefPrefix is where uncommitted work is parked while its branch is not checked out.
+const WIPRefPrefix = "refs/luna/wip/"
+
+func wipRefName(branchName string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(WIPRefPrefix + branchName)
+}
+
+func (r *gitRepository) SaveWIP(ctx context.Context, branchName string) (bool, error) {
+	repo, err := r.open()
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return false, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+	if status.IsClean() {
+		return false, nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return false, fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+
+	if _, err := worktree.Add("."); err != nil {
+		return false, fmt.Errorf("failed to stage files: %w", err)
+	}
+
+	treeHash, err := writeIndexTree(repo)
+	if err != nil {
+		return false, fmt.Errorf("failed to write WIP tree: %w", err)
+	}
+
+	author, committer, err := r.GetSignatures()
+	if err != nil {
+		return false, fmt.Errorf("failed to get user signature: %w", err)
+	}
+
+	wipCommit := &object.Commit{
+		Author:       *author,
+		Committer:    *committer,
+		Message:      fmt.Sprintf("luna: WIP on %s", branchName),
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	}
+
+	wipHash, err := storeCommit(repo, wipCommit)
+	if err != nil {
+		return false, fmt.Errorf("failed to store WIP commit: %w", err)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(wipRefName(branchName), wipHash)); err != nil {
+		return false, fmt.Errorf("failed to save WIP reference: %w", err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return false, fmt.Errorf("failed to clean worktree: %w", err)
+	}
+
+	return true, nil
+}
+
+func (r *gitRepository) RestoreWIP(ctx context.Context, branchName string) (bool, []string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	wipRef, err := repo.Reference(wipRefName(branchName), true)
+	if err == plumbing.ErrReferenceNotFound {
+		return false, nil, nil
+	}
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get WIP reference: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+
+	wipCommit, err := repo.CommitObject(wipRef.Hash())
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to get WIP commit: %w", err)
+	}
+
+	treeHash, conflicts, err := carryChanges(repo, wipCommit, head.Hash(), branchName, "wip")
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to merge WIP changes: %w", err)
+	}
+
+	restoreCommit := &object.Commit{
+		Author:       wipCommit.Author,
+		Committer:    wipCommit.Committer,
+		Message:      wipCommit.Message,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	}
+
+	restoreHash, err := storeCommit(repo, restoreCommit)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to store WIP commit: %w", err)
+	}
+
+	if err := worktree.Reset(&git.ResetOptions{Commit: restoreHash, Mode: git.HardReset}); err != nil {
+		return false, nil, fmt.Errorf("failed to restore WIP files: %w", err)
+	}
+
+	// Move the branch back so the restored files show up as uncommitted changes
+	if err := worktree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.MixedReset}); err != nil {
+		return false, nil, fmt.Errorf("failed to restore branch tip: %w", err)
+	}
+
+	if err := repo.Storer.RemoveReference(wipRefName(branchName)); err != nil {
+		return false, nil, fmt.Errorf("failed to delete WIP reference: %w", err)
+	}
+
+	return true, conflicts, nil
+}
+
+func (r *gitRepository) SnapshotWorktree(ctx context.Context) (string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	status, err := worktree.Status()
+	if err != nil {
+		return "", fmt.Errorf("failed to get worktree status: %w", err)
+	}
+	if status.IsClean() {
+		return "", nil
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+
+	// Stage everything to build the tree, then put the user's index back
+	index, err := repo.Storer.Index()
+	if err != nil {
+		return "", fmt.Errorf("failed to read index: %w", err)
+	}
+
+	if _, err := worktree.Add("."); err != nil {
+		return "", fmt.Errorf("failed to stage files: %w", err)
+	}
+
+	treeHash, err := writeIndexTree(repo)
+	if err != nil {
+		return "", fmt.Errorf("failed to write snapshot tree: %w", err)
+	}
+
+	if err := repo.Storer.SetIndex(index); err != nil {
+		return "", fmt.Errorf("failed to restore index: %w", err)
+	}
+
+	headCommit, err := repo.CommitObject(head.Hash())
+	if err != nil {
+		return "", fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+
+	// A fixed signature keeps the snapshot of identical files on the same HEAD identical
+	signature := object.Signature{
+		Name:  "Luna",
+		Email: "luna@vcs.local",
+		When:  headCommit.Committer.When,
+	}
+
+	snapshotHash, err := storeCommit(repo, &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      "luna: worktree snapshot",
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{head.Hash()},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to store snapshot commit: %w", err)
+	}
+
+	return snapshotHash.String(), nil
+}
+
+func (r *gitRepository) CarrySnapshot(ctx context.Context, snapshotHash, tipHash string) (string, []string, error) {
+	repo, err := r.open()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	snapshot, err := repo.CommitObject(plumbing.NewHash(snapshotHash))
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to get snapshot commit: %w", err)
+	}
+
+	tip := plumbing.NewHash(tipHash)
+	treeHash, conflicts, err := carryChanges(repo, snapshot, tip, shortHash(tip), "uncommitted")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to merge uncommitted changes: %w", err)
+	}
+
+	carried, err := storeCommit(repo, &object.Commit{
+		Author:       snapshot.Author,
+		Committer:    snapshot.Committer,
+		Message:      snapshot.Message,
+		TreeHash:     treeHash,
+		ParentHashes: []plumbing.Hash{tip},
+	})
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to store snapshot commit: %w", err)
+	}
+
+	return carried.String(), conflicts, nil
+}
+
+// carryChanges returns the tree of onto with the changes changes made to its parent, and
+// the paths where they conflict, left with conflict markers.
+func carryChanges(repo *git.Repository, changes *object.Commit, onto plumbing.Hash, ontoLabel, changesLabel string) (plumbing.Hash, []string, error) {
+	if len(changes.ParentHashes) != 1 || changes.ParentHashes[0] == onto {
+		return changes.TreeHash, nil, nil
+	}
+
+	parent, err := changes.Parent(0)
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to get parent of %s: %w", shortHash(changes.Hash), err)
+	}
+
+	parentTree, err := parent.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to get parent tree of %s: %w", shortHash(changes.Hash), err)
+	}
+
+	ontoCommit, err := repo.CommitObject(onto)
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to get commit %s: %w", onto, err)
+	}
+
+	ontoTree, err := ontoCommit.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to get tree of %s: %w", onto, err)
+	}
+
+	changesTree, err := changes.Tree()
+	if err != nil {
+		return plumbing.ZeroHash, nil, fmt.Errorf("failed to get tree of %s: %w", shortHash(changes.Hash), err)
+	}
+
+	result, err := mergeTrees(repo, parentTree, ontoTree, changesTree, ontoLabel, changesLabel)
+	if err != nil {
+		return plumbing.ZeroHash, nil, err
+	}
+
+	return result.TreeHash, result.conflictPaths(), nil
+}
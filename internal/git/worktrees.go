@@ -0,0 +1,261 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-billy/v6/osfs"
+	"github.com/go-git/go-git/v6"
+	"github.com/go-git/go-git/v6/plumbing"
+	"github.com/go-git/go-git/v6/plumbing/cache"
+	"github.com/go-git/go-git/v6/storage/filesystem"
+	"github.com/go-git/go-git/v6/storage/filesystem/dotgit"
+)
+
+// WorktreeInfo describes one of the worktrees of a repository.
+type WorktreeInfo struct {
+	// Name is the linked worktree's name under .git/worktrees, empty for the main worktree.
+	Name string `json:"name,omitempty"`
+	Path string `json:"path"`
+	// Branch is the checked out branch, empty when HEAD is detached.
+	Branch string `json:"branch,omitempty"`
+	Main   bool   `json:"main"`
+}
+
+// open opens the repository of the worktree at r.path, linked worktrees included.
+func (r *gitRepository) open() (*git.Repository, error) {
+	gitDir, commonDir, err := ResolveGitDirs(r.path)
+	if err != nil {
+		return nil, git.ErrRepositoryNotExists
+	}
+	if gitDir == commonDir {
+		return git.Open(filesystem.NewStorage(osfs.New(gitDir, osfs.WithBoundOS()), cache.NewObjectLRUDefault()), osfs.New(r.path, osfs.WithBoundOS()))
+	}
+
+	fs := &linkedGitFilesystem{
+		RepositoryFilesystem: dotgit.NewRepositoryFilesystem(osfs.New(gitDir, osfs.WithBoundOS()), osfs.New(commonDir, osfs.WithBoundOS())),
+		commonDir:            commonDir,
+	}
+
+	return git.Open(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), osfs.New(r.path, osfs.WithBoundOS()))
+}
+
+// linkedGitFilesystem works around go-git routing the absolute path of a temporary object
+// file, created in the common directory, to the worktree's own git directory on rename.
+type linkedGitFilesystem struct {
+	*dotgit.RepositoryFilesystem
+	commonDir string
+}
+
+func (fs *linkedGitFilesystem) relative(path string) string {
+	if !filepath.IsAbs(path) {
+		return path
+	}
+	if rel, err := filepath.Rel(fs.commonDir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return rel
+	}
+	return path
+}
+
+func (fs *linkedGitFilesystem) Rename(oldpath, newpath string) error {
+	return fs.RepositoryFilesystem.Rename(fs.relative(oldpath), fs.relative(newpath))
+}
+
+func (fs *linkedGitFilesystem) Remove(filename string) error {
+	return fs.RepositoryFilesystem.Remove(fs.relative(filename))
+}
+
+func (r *gitRepository) ListWorktrees(ctx context.Context) ([]WorktreeInfo, error) {
+	_, commonDir, err := ResolveGitDirs(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find git directory: %w", err)
+	}
+
+	main := WorktreeInfo{Path: filepath.Dir(commonDir), Main: true}
+	main.Branch = readHeadBranch(commonDir)
+	worktrees := []WorktreeInfo{main}
+
+	entries, err := os.ReadDir(filepath.Join(commonDir, "worktrees"))
+	if os.IsNotExist(err) {
+		return worktrees, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		adminDir := filepath.Join(commonDir, "worktrees", entry.Name())
+
+		gitdir, err := os.ReadFile(filepath.Join(adminDir, "gitdir"))
+		if err != nil {
+			continue
+		}
+		path := filepath.Dir(strings.TrimSpace(string(gitdir)))
+
+		// Deleted by hand, git would prune it
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+
+		worktrees = append(worktrees, WorktreeInfo{
+			Name:   entry.Name(),
+			Path:   path,
+			Branch: readHeadBranch(adminDir),
+		})
+	}
+
+	return worktrees, nil
+}
+
+// readHeadBranch returns the branch HEAD points to in a git directory, "" if detached.
+func readHeadBranch(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return ""
+	}
+
+	ref, found := strings.CutPrefix(strings.TrimSpace(string(data)), "ref: ")
+	if !found {
+		return ""
+	}
+
+	return plumbing.ReferenceName(ref).Short()
+}
+
+func (r *gitRepository) AddWorktree(ctx context.Context, path, branchName string) error {
+	_, commonDir, err := ResolveGitDirs(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to find git directory: %w", err)
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve worktree path: %w", err)
+	}
+
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 {
+		return fmt.Errorf("%s already exists and is not empty", path)
+	}
+
+	tip, err := r.GetBranchHash(ctx, branchName)
+	if err != nil {
+		return err
+	}
+
+	name := filepath.Base(path)
+	adminDir := filepath.Join(commonDir, "worktrees", name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(adminDir); os.IsNotExist(err) {
+			break
+		}
+		adminDir = filepath.Join(commonDir, "worktrees", fmt.Sprintf("%s%d", name, i))
+	}
+
+	if err := os.MkdirAll(adminDir, 0755); err != nil {
+		return fmt.Errorf("failed to create worktree directory: %w", err)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create worktree: %w", err)
+	}
+
+	files := map[string]string{
+		filepath.Join(adminDir, "HEAD"):      fmt.Sprintf("ref: %s\n", plumbing.NewBranchReferenceName(branchName)),
+		filepath.Join(adminDir, "commondir"): "../..\n",
+		filepath.Join(adminDir, "gitdir"):    filepath.Join(path, ".git") + "\n",
+		filepath.Join(path, ".git"):          fmt.Sprintf("gitdir: %s\n", adminDir),
+	}
+	for file, content := range files {
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+
+	if err := (&gitRepository{path: path}).resetHard(plumbing.NewHash(tip)); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", branchName, err)
+	}
+
+	return nil
+}
+
+func (r *gitRepository) RemoveWorktree(ctx context.Context, path string) error {
+	_, commonDir, err := ResolveGitDirs(r.path)
+	if err != nil {
+		return fmt.Errorf("failed to find git directory: %w", err)
+	}
+
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("failed to resolve worktree path: %w", err)
+	}
+
+	if info, err := os.Stat(path); err != nil || !info.IsDir() {
+		return nil
+	}
+
+	data, err := os.ReadFile(filepath.Join(path, ".git"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filepath.Join(path, ".git"), err)
+	}
+
+	// Only ever delete a linked worktree of this repository
+	adminDir, found := strings.CutPrefix(strings.TrimSpace(string(data)), "gitdir: ")
+	if rel, err := filepath.Rel(filepath.Join(commonDir, "worktrees"), adminDir); !found || err != nil || strings.Contains(rel, string(filepath.Separator)) || strings.HasPrefix(rel, ".") {
+		return fmt.Errorf("%s is not a linked worktree of this repository", path)
+	}
+
+	if err := os.RemoveAll(adminDir); err != nil {
+		return fmt.Errorf("failed to remove worktree directory: %w", err)
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to remove worktree: %w", err)
+	}
+
+	return nil
+}
+
+// resetHard makes the index and worktree at r.path match hash, moving the checked out branch.
+func (r *gitRepository) resetHard(hash plumbing.Hash) error {
+	repo, err := r.open()
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	return worktree.Reset(&git.ResetOptions{Commit: hash, Mode: git.HardReset})
+}
+
+// otherWorktreesOn returns the worktrees other than this one that have branchName checked out.
+func (r *gitRepository) otherWorktreesOn(ctx context.Context, branchName string) ([]WorktreeInfo, error) {
+	worktrees, err := r.ListWorktrees(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	self, err := filepath.Abs(r.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+
+	var others []WorktreeInfo
+	for _, worktree := range worktrees {
+		if worktree.Branch == branchName && filepath.Clean(worktree.Path) != self {
+			others = append(others, worktree)
+		}
+	}
+
+	return others, nil
+}
@@ -0,0 +1,488 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// Config keys and their defaults.
+const (
+	ConfigTrunk               = "trunk.name"
+	ConfigBranchPrefix        = "workspace.branch_prefix"
+	ConfigInitialMessage      = "messages.initial_commit"
+	ConfigFinalChangesMessage = "messages.final_changes"
+	ConfigFinalStepMessage    = "messages.final_step"
+	ConfigSquashTemplate      = "messages.squash_template"
+	ConfigUserName            = "user.name"
+	ConfigUserEmail           = "user.email"
+	ConfigEditor              = "core.editor"
+	ConfigPager               = "core.pager"
+	ConfigHookPreStep         = "hooks.pre_step"
+	ConfigHookPreLand         = "hooks.pre_land"
+	ConfigHookPostLand        = "hooks.post_land"
+	ConfigRequireSigned       = "policy.require_signed"
+)
+
+var configDefaults = map[string]string{
+	ConfigTrunk:               "luna",
+	ConfigBranchPrefix:        "",
+	ConfigInitialMessage:      "Initial commit",
+	ConfigFinalChangesMessage: "Final changes",
+	ConfigFinalStepMessage:    "Final workspace changes",
+}
+
+// repositoryWideKeys decide which branch is which, they cannot differ between workspaces.
+var repositoryWideKeys = map[string]bool{
+	ConfigTrunk:        true,
+	ConfigBranchPrefix: true,
+}
+
+// ConfigScope is a layer of the configuration. Each one overrides the ones before it.
+type ConfigScope string
+
+const (
+	ConfigScopeDefault   ConfigScope = "default"
+	ConfigScopeSystem    ConfigScope = "system"
+	ConfigScopeUser      ConfigScope = "user"
+	ConfigScopeRepo      ConfigScope = "repo"
+	ConfigScopeLocal     ConfigScope = "local"
+	ConfigScopeWorkspace ConfigScope = "workspace"
+)
+
+// ConfigScopes are the layers read from files, lowest precedence first.
+var ConfigScopes = []ConfigScope{ConfigScopeSystem, ConfigScopeUser, ConfigScopeRepo, ConfigScopeLocal, ConfigScopeWorkspace}
+
+// ConfigEntry is a setting and where it comes from.
+type ConfigEntry struct {
+	Key   string      `json:"key"`
+	Value string      `json:"value"`
+	Scope ConfigScope `json:"scope"`
+	// Origin is the file the value is read from, empty for defaults.
+	Origin string `json:"origin,omitempty"`
+}
+
+// Config is the luna configuration of a repository.
+type Config struct {
+	// Trunk is the branch workspaces start from and land on.
+	Trunk string
+	// BranchPrefix is prepended to a workspace name to get its branch.
+	BranchPrefix string
+	// InitialMessage is the message of the commit luna init creates.
+	InitialMessage string
+	// FinalChangesMessage is the commit message of changes left uncommitted when a
+	// workspace without steps is finished.
+	FinalChangesMessage string
+	// FinalStepMessage describes the step recording changes left uncommitted at finish.
+	FinalStepMessage string
+	// SquashTemplate is the file the message of squashed commits is rendered from, relative
+	// to the repository root. Empty for the built-in template.
+	SquashTemplate string
+	// RequireSigned refuses to land a workspace unless the commit landing is signed.
+	RequireSigned bool
+
+	values map[string]string
+}
+
+// Get returns the value of any setting, "" when it is not set.
+func (c *Config) Get(key string) string {
+	return c.values[key]
+}
+
+// WorkspaceBranch returns the branch of a workspace.
+func (c *Config) WorkspaceBranch(name string) string {
+	return c.BranchPrefix + name
+}
+
+// WorkspaceName returns the workspace a branch belongs to, false for the trunk and for
+// branches outside the workspace prefix.
+func (c *Config) WorkspaceName(branch string) (string, bool) {
+	if branch == c.Trunk {
+		return "", false
+	}
+	name, found := strings.CutPrefix(branch, c.BranchPrefix)
+	if !found || name == "" {
+		return "", false
+	}
+	return name, true
+}
+
+// ConfigService reads and writes the luna configuration. From lowest to highest
+// precedence it is made of the system file, the user's ~/.config/luna/config.toml,
+// .luna/config.toml committed with the project, .git/luna.toml for this clone only, and
+// the settings of the current workspace.
+type ConfigService struct {
+	repoPath string
+	repo     git.Repository
+}
+
+// NewConfigService returns the config of the repository at repoPath. Without repo, the
+// workspace layer is left out.
+func NewConfigService(repoPath string, repo git.Repository) *ConfigService {
+	return &ConfigService{repoPath: repoPath, repo: repo}
+}
+
+// RepoConfigPath is the configuration shared through the repository.
+func (c *ConfigService) RepoConfigPath() string {
+	return filepath.Join(c.repoPath, ".luna", "config.toml")
+}
+
+// LocalConfigPath is the configuration of this clone, never committed.
+func (c *ConfigService) LocalConfigPath() string {
+	return filepath.Join(c.commonDir(), "luna.toml")
+}
+
+// Path returns the file of a scope. The workspace scope is the file of the current
+// workspace, an error when there is none.
+func (c *ConfigService) Path(scope ConfigScope) (string, error) {
+	switch scope {
+	case ConfigScopeSystem:
+		return filepath.Join(string(filepath.Separator), "etc", "luna", "config.toml"), nil
+	case ConfigScopeUser:
+		dir := os.Getenv("XDG_CONFIG_HOME")
+		if dir == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				return "", fmt.Errorf("failed to find the user config directory: %w", err)
+			}
+			dir = filepath.Join(home, ".config")
+		}
+		return filepath.Join(dir, "luna", "config.toml"), nil
+	case ConfigScopeRepo:
+		return c.RepoConfigPath(), nil
+	case ConfigScopeLocal:
+		return c.LocalConfigPath(), nil
+	case ConfigScopeWorkspace:
+		workspace, err := c.currentWorkspace()
+		if err != nil {
+			return "", err
+		}
+		if workspace == "" {
+			return "", fmt.Errorf("no active workspace")
+		}
+		return c.workspaceConfigPath(workspace), nil
+	}
+	return "", fmt.Errorf("unknown config scope '%s'", scope)
+}
+
+func (c *ConfigService) workspaceConfigPath(workspace string) string {
+	return filepath.Join(c.commonDir(), "luna-workspaces", workspace+".toml")
+}
+
+func (c *ConfigService) commonDir() string {
+	return NewMetadataService(c.repoPath, nil).commonDir()
+}
+
+// Entries returns every setting of every layer, defaults first and the settings that win
+// last, so a key listed twice takes its last value.
+func (c *ConfigService) Entries() ([]ConfigEntry, error) {
+	entries, err := c.entries(ConfigScopeLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	workspace, err := c.currentWorkspace()
+	if err != nil {
+		return nil, err
+	}
+	if workspace == "" {
+		return entries, nil
+	}
+
+	path := c.workspaceConfigPath(workspace)
+	layer, err := readConfigEntries(path, ConfigScopeWorkspace)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range layer {
+		if repositoryWideKeys[entry.Key] {
+			return nil, fmt.Errorf("invalid config %s: %s cannot be set per workspace", path, entry.Key)
+		}
+	}
+
+	return append(entries, layer...), nil
+}
+
+// entries returns the defaults and the layers up to last.
+func (c *ConfigService) entries(last ConfigScope) ([]ConfigEntry, error) {
+	var entries []ConfigEntry
+	for _, key := range sortedKeys(configDefaults) {
+		entries = append(entries, ConfigEntry{Key: key, Value: configDefaults[key], Scope: ConfigScopeDefault})
+	}
+
+	for _, scope := range ConfigScopes {
+		path, err := c.Path(scope)
+		if err != nil {
+			return nil, err
+		}
+
+		layer, err := readConfigEntries(path, scope)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, layer...)
+
+		if scope == last {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// Get returns the setting of key that wins, nil when no layer sets it.
+func (c *ConfigService) Get(key string) (*ConfigEntry, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *ConfigEntry
+	for i := range entries {
+		if entries[i].Key == key {
+			found = &entries[i]
+		}
+	}
+	return found, nil
+}
+
+// Set writes key = value in the file of scope, keeping the rest of the file as it is.
+func (c *ConfigService) Set(scope ConfigScope, key, value string) error {
+	if err := validateConfigKey(key); err != nil {
+		return err
+	}
+	if scope == ConfigScopeWorkspace && repositoryWideKeys[key] {
+		return fmt.Errorf("%s cannot be set per workspace", key)
+	}
+
+	return c.editConfigFile(scope, func(data string) (string, error) {
+		return setTOMLValue(data, key, value)
+	})
+}
+
+// Unset removes key from the file of scope.
+func (c *ConfigService) Unset(scope ConfigScope, key string) error {
+	return c.editConfigFile(scope, func(data string) (string, error) {
+		edited, found, err := unsetTOMLValue(data, key)
+		if err != nil {
+			return "", err
+		}
+		if !found {
+			return "", fmt.Errorf("%s is not set in the %s config", key, scope)
+		}
+		return edited, nil
+	})
+}
+
+// editConfigFile rewrites the file of scope, and puts it back as it was if the result
+// is not a valid configuration. A file left empty is removed.
+func (c *ConfigService) editConfigFile(scope ConfigScope, edit func(data string) (string, error)) error {
+	if scope != ConfigScopeSystem && scope != ConfigScopeUser {
+		isRepo := false
+		if c.repo != nil {
+			var err error
+			if isRepo, err = c.repo.IsRepository(c.repoPath); err != nil {
+				return fmt.Errorf("failed to check repository: %w", err)
+			}
+		}
+		if !isRepo {
+			return fmt.Errorf("not a luna repository - use --user or --system outside of one")
+		}
+	}
+
+	path, err := c.Path(scope)
+	if err != nil {
+		return err
+	}
+
+	original, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	existed := err == nil
+
+	if _, err := parseConfig(string(original)); err != nil {
+		return fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	edited, err := edit(string(original))
+	if err != nil {
+		return err
+	}
+
+	if err := writeConfigFile(path, edited); err != nil {
+		return err
+	}
+
+	if _, err := c.Load(); err != nil {
+		if existed {
+			_ = writeConfigFile(path, string(original))
+		} else {
+			_ = os.Remove(path)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// Identity returns user.name and user.email as set in luna, overriding the identity
+// configured in git.
+func (c *ConfigService) Identity() (git.Identity, error) {
+	config, err := c.Load()
+	if err != nil {
+		return git.Identity{}, fmt.Errorf("failed to load config: %w", err)
+	}
+	return git.Identity{Name: config.Get(ConfigUserName), Email: config.Get(ConfigUserEmail)}, nil
+}
+
+// RemoveWorkspace forgets the settings of a workspace.
+func (c *ConfigService) RemoveWorkspace(name string) error {
+	if err := os.Remove(c.workspaceConfigPath(name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove workspace config: %w", err)
+	}
+	return nil
+}
+
+// Load returns the configuration, defaults filled in.
+func (c *ConfigService) Load() (*Config, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+
+	return newConfig(values)
+}
+
+func newConfig(values map[string]string) (*Config, error) {
+	config := &Config{
+		Trunk:               values[ConfigTrunk],
+		BranchPrefix:        values[ConfigBranchPrefix],
+		InitialMessage:      values[ConfigInitialMessage],
+		FinalChangesMessage: values[ConfigFinalChangesMessage],
+		FinalStepMessage:    values[ConfigFinalStepMessage],
+		SquashTemplate:      values[ConfigSquashTemplate],
+		values:              values,
+	}
+
+	if config.Trunk == "" {
+		return nil, fmt.Errorf("%s cannot be empty", ConfigTrunk)
+	}
+	if strings.HasPrefix(config.Trunk, config.BranchPrefix) && config.BranchPrefix != "" {
+		return nil, fmt.Errorf("%s '%s' cannot start with %s '%s'", ConfigTrunk, config.Trunk, ConfigBranchPrefix, config.BranchPrefix)
+	}
+	if value := values[ConfigRequireSigned]; value != "" {
+		requireSigned, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be true or false, not '%s'", ConfigRequireSigned, value)
+		}
+		config.RequireSigned = requireSigned
+	}
+
+	return config, nil
+}
+
+// currentWorkspace returns the workspace HEAD is on, "" when there is none. Which branch
+// belongs to which workspace is settled by the layers below the workspace one.
+func (c *ConfigService) currentWorkspace() (string, error) {
+	if c.repo == nil {
+		return "", nil
+	}
+
+	branch, err := c.repo.GetCurrentBranch(context.Background())
+	if err != nil {
+		// Detached, or not a repository yet
+		return "", nil
+	}
+
+	entries, err := c.entries(ConfigScopeLocal)
+	if err != nil {
+		return "", err
+	}
+	values := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		values[entry.Key] = entry.Value
+	}
+	config, err := newConfig(values)
+	if err != nil {
+		return "", err
+	}
+
+	name, _ := config.WorkspaceName(branch)
+	return name, nil
+}
+
+// validateConfigKey accepts section.name keys, sections possibly dotted themselves.
+func validateConfigKey(key string) error {
+	if !validTOMLKey(key) || !strings.Contains(key, ".") {
+		return fmt.Errorf("invalid key '%s' - keys look like section.name", key)
+	}
+	return nil
+}
+
+// splitConfigKey splits a key into its table and its name within the table.
+func splitConfigKey(key string) (string, string) {
+	i := strings.LastIndex(key, ".")
+	return key[:i], key[i+1:]
+}
+
+// readConfigEntries returns the settings of a config file in file order, nil if it does
+// not exist.
+func readConfigEntries(path string, scope ConfigScope) ([]ConfigEntry, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	entries, err := parseConfig(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("invalid config %s: %w", path, err)
+	}
+
+	for i := range entries {
+		entries[i].Scope = scope
+		entries[i].Origin = path
+	}
+
+	return entries, nil
+}
+
+func writeConfigFile(path, data string) error {
+	if data == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove config: %w", err)
+		}
+		return nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+func sortedKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
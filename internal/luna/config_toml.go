@@ -0,0 +1,367 @@
+package luna
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+)
+
+// parseConfig reads a config file and returns its settings in file order. Values are kept
+// as strings under their dotted key, "table.key"; arrays and inline tables are kept as
+// they would be written in TOML.
+func parseConfig(data string) ([]ConfigEntry, error) {
+	var document map[string]any
+	meta, err := toml.Decode(data, &document)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []ConfigEntry
+	for _, key := range meta.Keys() {
+		// Tables only group settings, and what is inside an array of tables is part of
+		// the array's value
+		if meta.Type(key...) == "Hash" {
+			continue
+		}
+		value, ok := lookupTOMLValue(document, key)
+		if !ok {
+			continue
+		}
+		entries = append(entries, ConfigEntry{Key: key.String(), Value: formatTOMLValue(value, false)})
+	}
+
+	return entries, nil
+}
+
+func lookupTOMLValue(document map[string]any, key toml.Key) (any, bool) {
+	var value any = document
+	for _, part := range key {
+		table, ok := value.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if value, ok = table[part]; !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// formatTOMLValue returns a value as a setting holds it. Strings nested in an array or
+// an inline table are quoted, top level ones are not.
+func formatTOMLValue(value any, nested bool) string {
+	switch v := value.(type) {
+	case string:
+		if nested {
+			return quoteTOML(v)
+		}
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case []map[string]any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatTOMLValue(item, true)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = formatTOMLValue(item, true)
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		items := make([]string, len(keys))
+		for i, key := range keys {
+			items[i] = key + " = " + formatTOMLValue(v[key], true)
+		}
+		return "{" + strings.Join(items, ", ") + "}"
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// tomlLine is a setting, a table header or any other line of a config file, kept as written
+// so edits leave the rest of the file alone. Key is the dotted key a setting sets,
+// "table.key", empty for headers, comments and blank lines. A setting whose value spans
+// several lines is a single tomlLine.
+type tomlLine struct {
+	text   string
+	table  string
+	header bool
+	key    string
+}
+
+// scanTOMLLines splits a config file, valid TOML, into lines. Only bare keys are recognized:
+// settings under quoted keys or arrays of tables are kept but never matched by a key.
+func scanTOMLLines(data string) []tomlLine {
+	var lines []tomlLine
+	table := ""
+
+	if data == "" {
+		return nil
+	}
+
+	var open tomlValueState
+	for _, text := range strings.Split(strings.TrimSuffix(data, "\n"), "\n") {
+		if open.open() {
+			last := &lines[len(lines)-1]
+			last.text += "\n" + text
+			open = open.scan(text)
+			continue
+		}
+
+		line := strings.TrimSpace(text)
+		if line == "" || strings.HasPrefix(line, "#") {
+			lines = append(lines, tomlLine{text: text, table: table})
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			table = "["
+			if name, ok := tableHeaderName(line); ok {
+				table = name
+			}
+			lines = append(lines, tomlLine{text: text, table: table, header: true})
+			continue
+		}
+
+		key := ""
+		rawKey, rest, _ := strings.Cut(line, "=")
+		if name := normalizeTOMLKey(rawKey); validTOMLKey(name) && (table == "" || validTOMLKey(table)) {
+			key = name
+			if table != "" {
+				key = table + "." + name
+			}
+		}
+
+		lines = append(lines, tomlLine{text: text, table: table, key: key})
+		open = tomlValueState{}.scan(rest)
+	}
+
+	return lines
+}
+
+// tableHeaderName returns the bare dotted name of a [table] header line.
+func tableHeaderName(line string) (string, bool) {
+	if strings.HasPrefix(line, "[[") {
+		return "", false
+	}
+	end := strings.Index(line, "]")
+	if end < 0 {
+		return "", false
+	}
+	name := normalizeTOMLKey(line[1:end])
+	return name, validTOMLKey(name)
+}
+
+// normalizeTOMLKey drops the spaces TOML allows around the dots of a dotted key.
+func normalizeTOMLKey(key string) string {
+	parts := strings.Split(strings.TrimSpace(key), ".")
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(part)
+	}
+	return strings.Join(parts, ".")
+}
+
+// tomlValueState follows a value across lines: the arrays and inline tables it is in and
+// the multi-line string it is in, with its delimiter.
+type tomlValueState struct {
+	depth     int
+	multiline string
+}
+
+func (s tomlValueState) open() bool {
+	return s.depth > 0 || s.multiline != ""
+}
+
+// scan returns the state after text.
+func (s tomlValueState) scan(text string) tomlValueState {
+	for i := 0; i < len(text); i++ {
+		if s.multiline != "" {
+			if s.multiline == `"""` && text[i] == '\\' {
+				i++
+				continue
+			}
+			if strings.HasPrefix(text[i:], s.multiline) {
+				i += len(s.multiline) - 1
+				s.multiline = ""
+			}
+			continue
+		}
+
+		switch c := text[i]; {
+		case strings.HasPrefix(text[i:], `"""`), strings.HasPrefix(text[i:], `'''`):
+			s.multiline = text[i : i+3]
+			i += 2
+		case c == '"':
+			for i++; i < len(text) && text[i] != '"'; i++ {
+				if text[i] == '\\' {
+					i++
+				}
+			}
+		case c == '\'':
+			for i++; i < len(text) && text[i] != '\''; i++ {
+			}
+		case c == '#':
+			return s
+		case c == '[' || c == '{':
+			s.depth++
+		case c == ']' || c == '}':
+			s.depth--
+		}
+	}
+	return s
+}
+
+// setTOMLValue returns data with key set to value, leaving comments and the other
+// settings as they are. A new key goes at the end of its table, created if needed.
+func setTOMLValue(data, key, value string) (string, error) {
+	lines := scanTOMLLines(data)
+
+	table, name := splitConfigKey(key)
+	assignment := name + " = " + quoteTOML(value)
+
+	for i, line := range lines {
+		if line.key != key {
+			continue
+		}
+		// A dotted key written under a parent table keeps its spelling
+		prefix := strings.TrimPrefix(key, line.table+".")
+		if line.table == "" {
+			prefix = key
+		}
+		lines[i].text = prefix + " = " + quoteTOML(value)
+		return joinTOMLLines(lines), nil
+	}
+
+	// After the last setting of the table, or right after its header when it has none
+	insertAt := -1
+	for i, line := range lines {
+		if line.table == table && (line.header || line.key != "") {
+			insertAt = i + 1
+		}
+	}
+
+	if insertAt < 0 {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1].text) != "" {
+			lines = append(lines, tomlLine{})
+		}
+		lines = append(lines, tomlLine{text: "[" + table + "]", table: table, header: true})
+		insertAt = len(lines)
+	}
+
+	lines = append(lines[:insertAt], append([]tomlLine{{text: assignment, table: table, key: key}}, lines[insertAt:]...)...)
+	return joinTOMLLines(lines), nil
+}
+
+// unsetTOMLValue returns data without key, and whether it was there. A table left without
+// any setting goes away with it.
+func unsetTOMLValue(data, key string) (string, bool, error) {
+	lines := scanTOMLLines(data)
+
+	found := -1
+	for i, line := range lines {
+		if line.key == key {
+			found = i
+			break
+		}
+	}
+	if found < 0 {
+		return data, false, nil
+	}
+
+	table := lines[found].table
+	lines = append(lines[:found], lines[found+1:]...)
+
+	header, empty := -1, true
+	for i, line := range lines {
+		if line.table != table {
+			continue
+		}
+		if line.header {
+			header = i
+		}
+		if line.key != "" || (!line.header && strings.TrimSpace(line.text) != "") {
+			empty = false
+		}
+	}
+	if header >= 0 && empty {
+		end := header + 1
+		for end < len(lines) && lines[end].table == table && !lines[end].header {
+			end++
+		}
+		lines = append(lines[:header], lines[end:]...)
+	}
+
+	return joinTOMLLines(lines), true, nil
+}
+
+func joinTOMLLines(lines []tomlLine) string {
+	// Drop the blank lines a removed table leaves at the end of the file
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1].text) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return ""
+	}
+
+	texts := make([]string, len(lines))
+	for i, line := range lines {
+		texts[i] = line.text
+	}
+	return strings.Join(texts, "\n") + "\n"
+}
+
+// quoteTOML writes value as a TOML basic string.
+func quoteTOML(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if unicode.IsControl(r) {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// validTOMLKey accepts bare keys, dotted or not.
+func validTOMLKey(key string) bool {
+	for _, part := range strings.Split(key, ".") {
+		if part == "" {
+			return false
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,98 @@
+package luna
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Operations that can stop on merge conflicts.
+const (
+	OperationFinish = "finish"
+	OperationSync   = "sync"
+)
+
+// ConflictState records a `ws done` or `ws sync` that stopped on merge conflicts.
+type ConflictState struct {
+	Operation       string    `json:"operation"`
+	Workspace       string    `json:"workspace"`
+	Description     string    `json:"description"`
+	Message         string    `json:"message,omitempty"`
+	TargetBranch    string    `json:"target_branch"`
+	TargetHash      string    `json:"target_hash"`
+	OriginalHash    string    `json:"original_hash"`
+	WorktreeHash    string    `json:"worktree_hash"`
+	ConflictedPaths []string  `json:"conflicted_paths"`
+	ResolvedPaths   []string  `json:"resolved_paths"`
+	StartedAt       time.Time `json:"started_at"`
+
+	// PendingCommits are the workspace commits still to replay during a sync, the first one is conflicted.
+	PendingCommits []string `json:"pending_commits,omitempty"`
+	// RewrittenHashes maps the workspace commits already replayed to their new hashes.
+	RewrittenHashes map[string]string `json:"rewritten_hashes,omitempty"`
+}
+
+// IsResolved reports whether the given conflicted path has been marked resolved.
+func (c *ConflictState) IsResolved(path string) bool {
+	for _, p := range c.ResolvedPaths {
+		if p == path {
+			return true
+		}
+	}
+	return false
+}
+
+// UnresolvedPaths returns the conflicted paths not yet marked resolved.
+func (c *ConflictState) UnresolvedPaths() []string {
+	var paths []string
+	for _, p := range c.ConflictedPaths {
+		if !c.IsResolved(p) {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+func (m *MetadataService) getConflictStatePath() string {
+	return filepath.Join(m.gitDir(), "conflict_state.json")
+}
+
+// LoadConflictState returns the in-progress conflicted operation, or nil if there is none.
+func (m *MetadataService) LoadConflictState() (*ConflictState, error) {
+	data, err := os.ReadFile(m.getConflictStatePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conflict state: %w", err)
+	}
+
+	var state ConflictState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse conflict state: %w", err)
+	}
+
+	return &state, nil
+}
+
+func (m *MetadataService) SaveConflictState(state *ConflictState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal conflict state: %w", err)
+	}
+
+	if err := writeFileAtomic(m.getConflictStatePath(), data); err != nil {
+		return fmt.Errorf("failed to write conflict state: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MetadataService) ClearConflictState() error {
+	if err := os.Remove(m.getConflictStatePath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove conflict state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package luna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// ErrDetachedHead is returned when a workspace is needed but HEAD is not on a branch.
+var ErrDetachedHead = errors.New("HEAD is detached - check out a workspace with 'luna ws switch <name>'")
+
+// CurrentWorkspace returns the workspace checked out, "" when HEAD is on the trunk or on a
+// branch that is not a workspace.
+func (s *WorkspaceService) CurrentWorkspace(ctx context.Context, repoPath string) (string, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return "", fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	workspace, _, err := s.deriveWorkspace(ctx, s.gitFactory.NewRepository(repoPath), metadata)
+	return workspace, err
+}
+
+// deriveWorkspace resolves the current workspace from the checked out branch, the only
+// source of truth; metadata.CurrentWorkspace is merely a cache of it. It returns the
+// workspace ("" if the branch is not one) and the branch.
+func (s *WorkspaceService) deriveWorkspace(ctx context.Context, repo git.Repository, metadata *LunaMetadata) (string, string, error) {
+	branch, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		if isRepo, _ := repo.IsRepository(repo.GetPath()); !isRepo {
+			return "", "", fmt.Errorf("not a luna repository")
+		}
+		return "", "", ErrDetachedHead
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return "", "", err
+	}
+
+	if name, ok := config.WorkspaceName(branch); ok {
+		if _, exists := metadata.Workspaces[name]; exists {
+			return name, branch, nil
+		}
+	}
+
+	return "", branch, nil
+}
+
+// requireWorkspace returns the checked out workspace, refusing detached HEADs and branches
+// that are not workspaces, and refreshes the cached current workspace.
+func (s *WorkspaceService) requireWorkspace(ctx context.Context, repo git.Repository, metadata *LunaMetadata) (string, error) {
+	workspace, branch, err := s.deriveWorkspace(ctx, repo, metadata)
+	if err != nil {
+		return "", err
+	}
+
+	if workspace == "" {
+		config, err := s.Config()
+		if err != nil {
+			return "", err
+		}
+		if branch == config.Trunk {
+			return "", fmt.Errorf("no active workspace - create one with 'luna ws create <name> <description>'")
+		}
+		return "", fmt.Errorf("branch '%s' is not a luna workspace - switch with 'luna ws switch <name>' or adopt it with 'luna doctor --fix'", branch)
+	}
+
+	if metadata.CurrentWorkspace != workspace {
+		metadata.CurrentWorkspace = workspace
+		if err := s.metadataService.SaveMetadata(metadata); err != nil {
+			return "", fmt.Errorf("failed to update metadata: %w", err)
+		}
+	}
+
+	return workspace, nil
+}
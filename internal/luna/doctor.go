@@ -0,0 +1,297 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// DoctorIssue is an inconsistency between the luna metadata and the git state.
+type DoctorIssue struct {
+	Workspace string `json:"workspace,omitempty"`
+	Problem   string `json:"problem"`
+	// Fix describes the repair, empty when the issue has to be fixed by hand.
+	Fix string `json:"fix,omitempty"`
+
+	apply func(ctx context.Context) error
+}
+
+// Apply repairs the issue.
+func (i *DoctorIssue) Apply(ctx context.Context) error {
+	if i.apply == nil {
+		return fmt.Errorf("no automatic fix for: %s", i.Problem)
+	}
+	return i.apply(ctx)
+}
+
+// Diagnose checks that the trunk exists, that the current workspace matches HEAD, that every
+// workspace has a branch holding all of its steps, and that no branch carries work
+// without metadata.
+func (s *WorkspaceService) Diagnose(ctx context.Context, repoPath string) ([]DoctorIssue, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	branches, err := repo.ListBranches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branchExists := make(map[string]bool, len(branches))
+	for _, branch := range branches {
+		branchExists[branch] = true
+	}
+
+	names := make([]string, 0, len(metadata.Workspaces))
+	for name := range metadata.Workspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var issues []DoctorIssue
+
+	if !branchExists[config.Trunk] {
+		issues = append(issues, s.missingTrunkIssue(ctx, repo, config, metadata, names))
+		// Every other check compares against the trunk
+		return issues, nil
+	}
+
+	if issue := s.checkCurrentWorkspace(ctx, repo, config, metadata); issue != nil {
+		issues = append(issues, *issue)
+	}
+
+	for _, name := range names {
+		if !branchExists[config.WorkspaceBranch(name)] {
+			issues = append(issues, s.missingBranchIssue(ctx, repo, config, metadata.Workspaces[name]))
+			continue
+		}
+
+		issue, err := s.checkSteps(ctx, repo, config, metadata.Workspaces[name])
+		if err != nil {
+			return nil, err
+		}
+		if issue != nil {
+			issues = append(issues, *issue)
+		}
+	}
+
+	for _, branch := range branches {
+		name, ok := config.WorkspaceName(branch)
+		if !ok {
+			continue
+		}
+		if _, known := metadata.Workspaces[name]; known {
+			continue
+		}
+
+		ahead, _, err := repo.AheadBehind(ctx, branch, config.Trunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare branch '%s' with %s: %w", branch, config.Trunk, err)
+		}
+		if ahead == 0 {
+			continue
+		}
+
+		issues = append(issues, DoctorIssue{
+			Workspace: name,
+			Problem:   fmt.Sprintf("branch '%s' carries %d commit(s) over %s but has no workspace metadata", branch, ahead, config.Trunk),
+			Fix:       "adopt it as a workspace, one step per commit",
+			apply: func(ctx context.Context) error {
+				workspace, err := rebuildWorkspace(ctx, repo, config, name)
+				if err != nil {
+					return err
+				}
+				return s.updateMetadata(func(metadata *LunaMetadata) {
+					metadata.Workspaces[name] = *workspace
+				})
+			},
+		})
+	}
+
+	return issues, nil
+}
+
+func (s *WorkspaceService) missingTrunkIssue(ctx context.Context, repo git.Repository, config *Config, metadata *LunaMetadata, names []string) DoctorIssue {
+	issue := DoctorIssue{Problem: fmt.Sprintf("the %s branch does not exist", config.Trunk)}
+
+	// The most recently landed workspace, or else the base of a workspace's first step
+	var target, origin string
+	var latest ArchivedWorkspace
+	for hash, archived := range metadata.Archived {
+		if _, err := repo.GetCommit(ctx, hash); err == nil && archived.LandedAt.After(latest.LandedAt) {
+			target, origin, latest = hash, "the last landed workspace", archived
+		}
+	}
+	for _, name := range names {
+		if target != "" {
+			break
+		}
+		if steps := metadata.Workspaces[name].Steps; len(steps) > 0 {
+			if hash, err := repo.ResolveRevision(ctx, steps[0].CommitHash+"^"); err == nil {
+				target, origin = hash, fmt.Sprintf("the base of workspace '%s'", name)
+			}
+		}
+	}
+
+	if target == "" {
+		return issue
+	}
+
+	issue.Fix = fmt.Sprintf("recreate %s at %s (%s)", config.Trunk, target[:7], origin)
+	issue.apply = func(ctx context.Context) error {
+		return repo.SetReference(ctx, "refs/heads/"+config.Trunk, target)
+	}
+	return issue
+}
+
+func (s *WorkspaceService) checkCurrentWorkspace(ctx context.Context, repo git.Repository, config *Config, metadata *LunaMetadata) *DoctorIssue {
+	head, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		return &DoctorIssue{Problem: fmt.Sprintf("HEAD is detached - check out %s or a workspace branch", config.Trunk)}
+	}
+
+	expected := ""
+	if name, ok := config.WorkspaceName(head); ok {
+		if _, isWorkspace := metadata.Workspaces[name]; isWorkspace {
+			expected = name
+		}
+	}
+	if metadata.CurrentWorkspace == expected {
+		return nil
+	}
+
+	issue := &DoctorIssue{
+		Workspace: metadata.CurrentWorkspace,
+		Problem:   fmt.Sprintf("current workspace is '%s' but HEAD is on '%s'", metadata.CurrentWorkspace, head),
+		Fix:       fmt.Sprintf("set the current workspace to '%s'", expected),
+		apply: func(ctx context.Context) error {
+			return s.updateMetadata(func(metadata *LunaMetadata) {
+				metadata.CurrentWorkspace = expected
+			})
+		},
+	}
+	if expected == "" {
+		issue.Fix = "clear the current workspace"
+	}
+	return issue
+}
+
+func (s *WorkspaceService) missingBranchIssue(ctx context.Context, repo git.Repository, config *Config, workspace WorkspaceMetadata) DoctorIssue {
+	name := workspace.Name
+	branch := config.WorkspaceBranch(name)
+
+	target, origin := "", config.Trunk
+	for i := len(workspace.Steps) - 1; i >= 0; i-- {
+		if _, err := repo.GetCommit(ctx, workspace.Steps[i].CommitHash); err == nil {
+			target, origin = workspace.Steps[i].CommitHash, fmt.Sprintf("its last step %s", workspace.Steps[i].CommitHash[:7])
+			break
+		}
+	}
+
+	return DoctorIssue{
+		Workspace: name,
+		Problem:   fmt.Sprintf("workspace '%s' has no branch", name),
+		Fix:       fmt.Sprintf("recreate the branch at %s", origin),
+		apply: func(ctx context.Context) error {
+			if target == "" {
+				return repo.CreateBranch(ctx, branch, config.Trunk)
+			}
+			return repo.SetReference(ctx, "refs/heads/"+branch, target)
+		},
+	}
+}
+
+func (s *WorkspaceService) checkSteps(ctx context.Context, repo git.Repository, config *Config, workspace WorkspaceMetadata) (*DoctorIssue, error) {
+	name := workspace.Name
+
+	commits, err := repo.ListBranchCommits(ctx, config.WorkspaceBranch(name), config.Trunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of '%s': %w", name, err)
+	}
+
+	onBranch := make(map[string]bool, len(commits))
+	for _, hash := range commits {
+		onBranch[hash] = true
+	}
+
+	var missing, elsewhere int
+	for _, step := range workspace.Steps {
+		if onBranch[step.CommitHash] {
+			continue
+		}
+		if _, err := repo.GetCommit(ctx, step.CommitHash); err != nil {
+			missing++
+		} else {
+			elsewhere++
+		}
+	}
+
+	if missing == 0 && elsewhere == 0 {
+		return nil, nil
+	}
+
+	problem := fmt.Sprintf("workspace '%s' has", name)
+	if missing > 0 {
+		problem += fmt.Sprintf(" %d step(s) whose commit does not exist", missing)
+	}
+	if missing > 0 && elsewhere > 0 {
+		problem += " and"
+	}
+	if elsewhere > 0 {
+		problem += fmt.Sprintf(" %d step(s) whose commit is not on its branch", elsewhere)
+	}
+
+	return &DoctorIssue{
+		Workspace: name,
+		Problem:   problem,
+		Fix:       "rebuild its steps from the branch commits",
+		apply: func(ctx context.Context) error {
+			rebuilt, err := rebuildWorkspace(ctx, repo, config, name)
+			if err != nil {
+				return err
+			}
+
+			// Keep what the metadata knows about steps that are still there
+			known := make(map[string]Step, len(workspace.Steps))
+			for _, step := range workspace.Steps {
+				known[step.CommitHash] = step
+			}
+			for i, step := range rebuilt.Steps {
+				if existing, ok := known[step.CommitHash]; ok {
+					rebuilt.Steps[i] = existing
+				}
+			}
+
+			return s.updateMetadata(func(metadata *LunaMetadata) {
+				current := metadata.Workspaces[name]
+				current.Steps = rebuilt.Steps
+				metadata.Workspaces[name] = current
+			})
+		},
+	}, nil
+}
+
+// updateMetadata loads the metadata, applies change and saves it.
+func (s *WorkspaceService) updateMetadata(change func(metadata *LunaMetadata)) error {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	change(metadata)
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to save metadata: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// DefaultDropRetention is how long a dropped workspace stays recoverable.
+const DefaultDropRetention = 30 * 24 * time.Hour
+
+// DropWorkspace abandons a workspace without landing it. Its tip, including any
+// uncommitted changes, stays under refs/luna/dropped/<key> for the retention period,
+// and its settings are kept until then. The key is the workspace name, followed by
+// @2, @3... when a workspace of the same name was dropped before and is still kept.
+// An empty name drops the current workspace. It returns the key to undrop it with.
+func (s *WorkspaceService) DropWorkspace(ctx context.Context, repoPath, name string, retention time.Duration) (string, error) {
+	if retention <= 0 {
+		return "", fmt.Errorf("the retention period must be positive, got %s", retention)
+	}
+
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return "", err
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return "", fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if name == "" {
+		if name, _, err = s.deriveWorkspace(ctx, s.gitFactory.NewRepository(repoPath), metadata); err != nil {
+			return "", err
+		}
+	}
+	if name == "" {
+		return "", fmt.Errorf("no active workspace")
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return "", err
+	}
+	if name == config.Trunk {
+		return "", fmt.Errorf("cannot drop the %s branch", config.Trunk)
+	}
+	branch := config.WorkspaceBranch(name)
+
+	workspace, exists := metadata.Workspaces[name]
+	if !exists {
+		return "", fmt.Errorf("workspace '%s' not found", name)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if err := s.ensureNotOpenElsewhere(ctx, repo, name); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	// Forget the expired ones first, so their keys are free again
+	if err := s.pruneDropped(ctx, repo, metadata, now); err != nil {
+		return "", err
+	}
+
+	key, err := droppedKey(ctx, repo, metadata, name)
+	if err != nil {
+		return "", err
+	}
+
+	currentBranch, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	if currentBranch == branch {
+		if _, err := repo.SaveWIP(ctx, branch); err != nil {
+			return "", fmt.Errorf("failed to save uncommitted changes: %w", err)
+		}
+
+		if err := repo.SwitchBranch(ctx, config.Trunk); err != nil {
+			return "", fmt.Errorf("failed to switch to %s: %w", config.Trunk, err)
+		}
+
+		if _, _, err := repo.RestoreWIP(ctx, config.Trunk); err != nil {
+			return "", fmt.Errorf("failed to restore uncommitted changes of %s: %w", config.Trunk, err)
+		}
+	}
+
+	branchHash, err := repo.GetBranchHash(ctx, branch)
+	if err != nil {
+		return "", fmt.Errorf("failed to get workspace tip: %w", err)
+	}
+
+	wipHash, err := repo.GetReference(ctx, git.WIPRefPrefix+branch)
+	if err != nil {
+		return "", fmt.Errorf("failed to get uncommitted changes: %w", err)
+	}
+
+	// The WIP commit sits on top of the branch tip, so keeping it keeps both
+	keepHash := branchHash
+	if wipHash != "" {
+		keepHash = wipHash
+	}
+
+	if err := repo.SetReference(ctx, git.DroppedRefPrefix+key, keepHash); err != nil {
+		return "", fmt.Errorf("failed to keep dropped workspace: %w", err)
+	}
+
+	if err := repo.DeleteBranch(ctx, branch); err != nil {
+		return "", fmt.Errorf("failed to delete workspace branch: %w", err)
+	}
+
+	if wipHash != "" {
+		if err := repo.RemoveReference(ctx, git.WIPRefPrefix+branch); err != nil {
+			return "", fmt.Errorf("failed to delete uncommitted changes: %w", err)
+		}
+	}
+
+	workspace.Name = name
+	metadata.Dropped[key] = DroppedWorkspace{
+		Workspace:  workspace,
+		BranchHash: branchHash,
+		WIPHash:    wipHash,
+		DroppedAt:  now,
+		ExpiresAt:  now.Add(retention),
+	}
+	delete(metadata.Workspaces, name)
+	if metadata.CurrentWorkspace == name {
+		metadata.CurrentWorkspace = ""
+	}
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return "", fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return key, nil
+}
+
+// droppedKey returns the key a workspace called name is dropped under: the name itself, or
+// name@2, name@3... when it is taken.
+func droppedKey(ctx context.Context, repo git.Repository, metadata *LunaMetadata, name string) (string, error) {
+	key := name
+	for n := 2; ; n++ {
+		if _, taken := metadata.Dropped[key]; !taken {
+			existing, err := repo.GetReference(ctx, git.DroppedRefPrefix+key)
+			if err != nil {
+				return "", fmt.Errorf("failed to check dropped workspaces: %w", err)
+			}
+			if existing == "" {
+				return key, nil
+			}
+		}
+		key = fmt.Sprintf("%s@%d", name, n)
+	}
+}
+
+// UndropWorkspace brings back a dropped workspace that has not expired yet, by the key
+// DropWorkspace returned. It returns the name of the recovered workspace.
+func (s *WorkspaceService) UndropWorkspace(ctx context.Context, repoPath, key string) (string, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return "", fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	dropped, exists := metadata.Dropped[key]
+	if !exists {
+		return "", fmt.Errorf("no dropped workspace named '%s'", key)
+	}
+	if !time.Now().Before(dropped.ExpiresAt) {
+		return "", fmt.Errorf("dropped workspace '%s' expired on %s", key, dropped.ExpiresAt.Format(time.DateTime))
+	}
+
+	name := dropped.Workspace.Name
+	if name == "" {
+		name = key
+	}
+	if _, exists := metadata.Workspaces[name]; exists {
+		return "", fmt.Errorf("workspace '%s' already exists", name)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return "", err
+	}
+	branch := config.WorkspaceBranch(name)
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if existing, err := repo.GetReference(ctx, "refs/heads/"+branch); err != nil {
+		return "", fmt.Errorf("failed to check branch: %w", err)
+	} else if existing != "" {
+		return "", fmt.Errorf("branch '%s' already exists", branch)
+	}
+
+	if err := repo.SetReference(ctx, "refs/heads/"+branch, dropped.BranchHash); err != nil {
+		return "", fmt.Errorf("failed to recreate workspace branch: %w", err)
+	}
+
+	if dropped.WIPHash != "" {
+		if err := repo.SetReference(ctx, git.WIPRefPrefix+branch, dropped.WIPHash); err != nil {
+			return "", fmt.Errorf("failed to restore uncommitted changes: %w", err)
+		}
+	}
+
+	if err := repo.RemoveReference(ctx, git.DroppedRefPrefix+key); err != nil {
+		return "", fmt.Errorf("failed to remove dropped reference: %w", err)
+	}
+
+	metadata.Workspaces[name] = dropped.Workspace
+	delete(metadata.Dropped, key)
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return "", fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return name, nil
+}
+
+// pruneDropped forgets dropped workspaces whose retention period is over, along with their
+// settings unless a workspace of the same name still needs them.
+func (s *WorkspaceService) pruneDropped(ctx context.Context, repo git.Repository, metadata *LunaMetadata, now time.Time) error {
+	for key, dropped := range metadata.Dropped {
+		if now.Before(dropped.ExpiresAt) {
+			continue
+		}
+
+		if err := repo.RemoveReference(ctx, git.DroppedRefPrefix+key); err != nil {
+			return fmt.Errorf("failed to prune dropped workspace '%s': %w", key, err)
+		}
+		delete(metadata.Dropped, key)
+
+		name := dropped.Workspace.Name
+		if name == "" {
+			name = key
+		}
+		if !workspaceNameInUse(metadata, name) {
+			if err := s.configService.RemoveWorkspace(name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// workspaceNameInUse reports whether a workspace called name exists or is dropped but kept.
+func workspaceNameInUse(metadata *LunaMetadata, name string) bool {
+	if _, exists := metadata.Workspaces[name]; exists {
+		return true
+	}
+	for key, dropped := range metadata.Dropped {
+		if dropped.Workspace.Name == name || dropped.Workspace.Name == "" && key == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// runHook runs the shell command a hooks.* setting holds, from the repository root, with
+// env added to the environment. Nothing runs when the setting is empty.
+func runHook(ctx context.Context, repoPath string, config *Config, key string, env ...string) error {
+	command := config.Get(key)
+	if command == "" {
+		return nil
+	}
+
+	hook := exec.CommandContext(ctx, "sh", "-c", command)
+	hook.Dir = repoPath
+	hook.Env = append(os.Environ(), env...)
+	hook.Stdout = os.Stdout
+	hook.Stderr = os.Stderr
+	if err := hook.Run(); err != nil {
+		return fmt.Errorf("%s '%s' failed: %w", key, command, err)
+	}
+
+	return nil
+}
+
+// checkLandPolicy refuses to land when policy.require_signed is set and the commit landing
+// would not be signed.
+func checkLandPolicy(config *Config, repo git.Repository) error {
+	if !config.RequireSigned {
+		return nil
+	}
+
+	signed, err := repo.SignsCommits()
+	if err != nil {
+		return err
+	}
+	if !signed {
+		return fmt.Errorf("%s is set but commits are not signed - set commit.gpgsign and user.signingkey in your git config", ConfigRequireSigned)
+	}
+
+	return nil
+}
@@ -0,0 +1,517 @@
+package luna
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// Operations recorded in the journal.
+const (
+	JournalCreate = "create"
+	JournalStep   = "step"
+	JournalFinish = "finish"
+)
+
+// Journal records a mutating operation before each of its steps runs, along with
+// the state needed to undo it, so an interrupted operation can be recovered.
+type Journal struct {
+	Operation   string `json:"operation"`
+	Phase       string `json:"phase"`
+	Workspace   string `json:"workspace"`
+	Description string `json:"description,omitempty"`
+	// Branch is the workspace branch and Trunk the branch it lands on, as configured when
+	// the operation started.
+	Branch string `json:"branch,omitempty"`
+	Trunk  string `json:"trunk,omitempty"`
+	// Continue is set when the interrupted finish was a `ws done --continue`.
+	Continue bool `json:"continue,omitempty"`
+	// Partial is set when the interrupted step committed only some of the changes.
+	Partial bool `json:"partial,omitempty"`
+	// Message is the message the interrupted finish lands the workspace with.
+	Message string `json:"message,omitempty"`
+	// Worktree is the linked worktree the interrupted create checks the workspace out in,
+	// empty when it is checked out in place.
+	Worktree string `json:"worktree,omitempty"`
+
+	// HeadBranch and Refs are the checked out branch and the branch tips before
+	// the operation started, an empty hash meaning the branch did not exist.
+	HeadBranch string            `json:"head_branch"`
+	Refs       map[string]string `json:"refs"`
+	// Metadata is metadata.json as it was before the operation, null if it did not exist.
+	Metadata json.RawMessage `json:"metadata"`
+
+	// WorktreeHash is a commit holding the uncommitted changes the operation committed.
+	WorktreeHash string    `json:"worktree_hash,omitempty"`
+	StepCommits  []string  `json:"step_commits,omitempty"`
+	StartedAt    time.Time `json:"started_at"`
+}
+
+func (m *MetadataService) getJournalPath() string {
+	return filepath.Join(m.gitDir(), "journal.json")
+}
+
+// LoadJournal returns the interrupted operation, or nil if there is none.
+func (m *MetadataService) LoadJournal() (*Journal, error) {
+	data, err := os.ReadFile(m.getJournalPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+
+	var journal Journal
+	if err := json.Unmarshal(data, &journal); err != nil {
+		return nil, fmt.Errorf("failed to parse journal: %w", err)
+	}
+
+	return &journal, nil
+}
+
+func (m *MetadataService) SaveJournal(journal *Journal) error {
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal journal: %w", err)
+	}
+
+	if err := writeFileAtomic(m.getJournalPath(), data); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+
+	return nil
+}
+
+func (m *MetadataService) ClearJournal() error {
+	if err := os.Remove(m.getJournalPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove journal: %w", err)
+	}
+	return nil
+}
+
+// readRawMetadata returns the metadata document as stored, or nil if it does not exist
+// or is corrupted.
+func (m *MetadataService) readRawMetadata() ([]byte, error) {
+	backend, err := m.backend()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := backend.read()
+	var corruptErr *CorruptMetadataError
+	if errors.As(err, &corruptErr) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data != nil && !json.Valid(data) {
+		return nil, nil
+	}
+	return data, nil
+}
+
+// writeRawMetadata puts back the metadata document exactly as returned by readRawMetadata.
+// A missing document comes back from the journal as null.
+func (m *MetadataService) writeRawMetadata(data []byte) error {
+	if data == nil || string(data) == "null" {
+		backend, err := m.backend()
+		if err != nil {
+			return err
+		}
+		return backend.remove()
+	}
+
+	return m.writeMetadata(data)
+}
+
+// beginJournal snapshots the branches and metadata an operation is about to touch.
+func (s *WorkspaceService) beginJournal(ctx context.Context, repo git.Repository, operation, workspace, description string) (*Journal, error) {
+	pending, err := s.metadataService.LoadJournal()
+	if err != nil {
+		return nil, err
+	}
+	if pending != nil {
+		return nil, fmt.Errorf("an interrupted '%s' of workspace '%s' needs recovery - run 'luna recover'", pending.Operation, pending.Workspace)
+	}
+
+	headBranch, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+	workspaceBranch := config.WorkspaceBranch(workspace)
+
+	refs := make(map[string]string)
+	for _, branch := range []string{config.Trunk, workspaceBranch, headBranch} {
+		hash, err := repo.GetReference(ctx, "refs/heads/"+branch)
+		if err != nil {
+			return nil, fmt.Errorf("failed to snapshot branch %s: %w", branch, err)
+		}
+		refs[branch] = hash
+	}
+
+	metadata, err := s.metadataService.readRawMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	journal := &Journal{
+		Operation:   operation,
+		Workspace:   workspace,
+		Description: description,
+		Branch:      workspaceBranch,
+		Trunk:       config.Trunk,
+		HeadBranch:  headBranch,
+		Refs:        refs,
+		Metadata:    metadata,
+		StartedAt:   time.Now(),
+	}
+
+	if err := s.metadataService.SaveJournal(journal); err != nil {
+		return nil, err
+	}
+
+	return journal, nil
+}
+
+// branch returns the workspace branch, journals written before it was recorded using the
+// workspace name.
+func (j *Journal) branch() string {
+	if j.Branch == "" {
+		return j.Workspace
+	}
+	return j.Branch
+}
+
+// trunk returns the trunk branch, "luna" for journals written before it was recorded.
+func (j *Journal) trunk() string {
+	if j.Trunk == "" {
+		return "luna"
+	}
+	return j.Trunk
+}
+
+// journalPhase records that the operation is about to run the given phase.
+func (s *WorkspaceService) journalPhase(journal *Journal, phase string) error {
+	journal.Phase = phase
+	return s.metadataService.SaveJournal(journal)
+}
+
+// abandonJournal undoes an operation that failed with an error rather than crashed, so
+// the error does not leave behind a journal demanding recovery. While the checked out
+// branch has not moved the worktree still holds the user's changes and is left alone.
+func (s *WorkspaceService) abandonJournal(ctx context.Context, repoPath string, err *error) {
+	if *err == nil {
+		return
+	}
+
+	journal, loadErr := s.metadataService.LoadJournal()
+	if loadErr != nil || journal == nil {
+		return
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	headBranch, headErr := repo.GetCurrentBranch(ctx)
+	headHash, refErr := repo.GetReference(ctx, "refs/heads/"+journal.HeadBranch)
+	if headErr != nil || refErr != nil {
+		return
+	}
+
+	var undoErr error
+	if headBranch != journal.HeadBranch || headHash != journal.Refs[journal.HeadBranch] {
+		undoErr = s.RollBack(ctx, repoPath)
+	} else if undoErr = restoreJournalRefs(ctx, repo, journal); undoErr == nil {
+		if undoErr = s.metadataService.writeRawMetadata(journal.Metadata); undoErr == nil {
+			undoErr = s.metadataService.ClearJournal()
+		}
+	}
+
+	if undoErr != nil {
+		*err = fmt.Errorf("%w (undoing it failed too: %v - run 'luna recover')", *err, undoErr)
+	}
+}
+
+// PendingJournal returns the operation that was interrupted, or nil if there is none.
+func (s *WorkspaceService) PendingJournal() (*Journal, error) {
+	return s.metadataService.LoadJournal()
+}
+
+// RollBack undoes an interrupted operation: branches and metadata go back to how they
+// were before it started, and any work it committed is left as uncommitted changes.
+func (s *WorkspaceService) RollBack(ctx context.Context, repoPath string) error {
+	journal, err := s.loadJournalInProgress()
+	if err != nil {
+		return err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	worktreeHash := journal.WorktreeHash
+	if worktreeHash == "" {
+		current, err := repo.GetReference(ctx, "refs/heads/"+journal.HeadBranch)
+		if err != nil {
+			return fmt.Errorf("failed to get branch %s: %w", journal.HeadBranch, err)
+		}
+		worktreeHash = current
+	}
+	if worktreeHash == "" {
+		worktreeHash = journal.Refs[journal.HeadBranch]
+	}
+
+	if err := restoreJournalRefs(ctx, repo, journal); err != nil {
+		return err
+	}
+
+	// A workspace created in its own worktree never touched this one
+	if journal.Worktree == "" {
+		if err := repo.RestoreBranch(ctx, journal.HeadBranch, journal.Refs[journal.HeadBranch], worktreeHash); err != nil {
+			return fmt.Errorf("failed to restore worktree: %w", err)
+		}
+	}
+
+	if err := s.metadataService.writeRawMetadata(journal.Metadata); err != nil {
+		return err
+	}
+
+	return s.metadataService.ClearJournal()
+}
+
+// restoreJournalRefs puts the branches back where they were before the operation started,
+// deleting the ones it created along with the worktree it created.
+func restoreJournalRefs(ctx context.Context, repo git.Repository, journal *Journal) error {
+	if journal.Worktree != "" {
+		if err := repo.RemoveWorktree(ctx, journal.Worktree); err != nil {
+			return fmt.Errorf("failed to remove worktree %s: %w", journal.Worktree, err)
+		}
+	}
+
+	for branch, hash := range journal.Refs {
+		if hash != "" {
+			if err := repo.SetReference(ctx, "refs/heads/"+branch, hash); err != nil {
+				return fmt.Errorf("failed to restore branch %s: %w", branch, err)
+			}
+			continue
+		}
+
+		existing, err := repo.GetReference(ctx, "refs/heads/"+branch)
+		if err != nil {
+			return fmt.Errorf("failed to get branch %s: %w", branch, err)
+		}
+		if existing != "" {
+			if err := repo.DeleteBranch(ctx, branch); err != nil {
+				return fmt.Errorf("failed to delete branch %s: %w", branch, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// RollForward completes an interrupted operation.
+func (s *WorkspaceService) RollForward(ctx context.Context, repoPath string) error {
+	journal, err := s.loadJournalInProgress()
+	if err != nil {
+		return err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	switch journal.Operation {
+	case JournalCreate:
+		return s.rollForwardCreate(ctx, repo, journal)
+	case JournalStep:
+		return s.rollForwardStep(ctx, repoPath, repo, journal)
+	case JournalFinish:
+		return s.rollForwardFinish(ctx, repoPath, repo, journal)
+	default:
+		return fmt.Errorf("unknown operation '%s' in journal", journal.Operation)
+	}
+}
+
+func (s *WorkspaceService) rollForwardCreate(ctx context.Context, repo git.Repository, journal *Journal) error {
+	branch := journal.branch()
+
+	branchHash, err := repo.GetReference(ctx, "refs/heads/"+branch)
+	if err != nil {
+		return fmt.Errorf("failed to get workspace branch: %w", err)
+	}
+
+	if branchHash == "" || branchHash == journal.Refs[branch] && journal.Phase == "branch" {
+		if err := repo.CreateBranch(ctx, branch, journal.trunk()); err != nil {
+			return fmt.Errorf("failed to create workspace branch: %w", err)
+		}
+	}
+
+	if journal.Worktree != "" {
+		if err := s.rollForwardWorktree(ctx, repo, journal); err != nil {
+			return err
+		}
+	} else if currentBranch, err := repo.GetCurrentBranch(ctx); err != nil || currentBranch != branch {
+		if err := repo.SwitchBranch(ctx, branch); err != nil {
+			return fmt.Errorf("failed to switch to workspace: %w", err)
+		}
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if _, exists := metadata.Workspaces[journal.Workspace]; !exists {
+		if err := s.metadataService.CreateWorkspace(journal.Workspace, journal.Description); err != nil {
+			return fmt.Errorf("failed to create workspace metadata: %w", err)
+		}
+	}
+
+	return s.metadataService.ClearJournal()
+}
+
+// rollForwardWorktree makes sure the worktree of an interrupted create exists with the
+// workspace fully checked out; nothing was worked on in it yet.
+func (s *WorkspaceService) rollForwardWorktree(ctx context.Context, repo git.Repository, journal *Journal) error {
+	branch := journal.branch()
+
+	worktrees, err := repo.ListWorktrees(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	for _, worktree := range worktrees {
+		if filepath.Clean(worktree.Path) != filepath.Clean(journal.Worktree) || worktree.Branch != branch {
+			continue
+		}
+
+		tip, err := repo.GetBranchHash(ctx, branch)
+		if err != nil {
+			return fmt.Errorf("failed to get workspace tip: %w", err)
+		}
+		if err := s.gitFactory.NewRepository(journal.Worktree).RestoreBranch(ctx, branch, tip, tip); err != nil {
+			return fmt.Errorf("failed to check out worktree %s: %w", journal.Worktree, err)
+		}
+		return nil
+	}
+
+	if err := repo.RemoveWorktree(ctx, journal.Worktree); err != nil {
+		return fmt.Errorf("failed to remove worktree %s: %w", journal.Worktree, err)
+	}
+	if err := repo.AddWorktree(ctx, journal.Worktree, branch); err != nil {
+		return fmt.Errorf("failed to create worktree: %w", err)
+	}
+
+	return nil
+}
+
+func (s *WorkspaceService) rollForwardStep(ctx context.Context, repoPath string, repo git.Repository, journal *Journal) error {
+	tip, err := repo.GetBranchHash(ctx, journal.branch())
+	if err != nil {
+		return fmt.Errorf("failed to get workspace tip: %w", err)
+	}
+
+	// The step commit never happened, simply run the step again
+	if tip == journal.Refs[journal.branch()] {
+		if err := s.metadataService.ClearJournal(); err != nil {
+			return err
+		}
+		// The selection of a partial step is gone, it has to be made again
+		if journal.Partial {
+			return fmt.Errorf("the partial step was not committed - run 'luna new' again")
+		}
+		return s.CreateStep(ctx, repoPath, journal.Description)
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	steps := metadata.Workspaces[journal.Workspace].Steps
+	if len(steps) == 0 || steps[len(steps)-1].CommitHash != tip {
+		if err := s.metadataService.AddStep(journal.Workspace, journal.Description, tip); err != nil {
+			return fmt.Errorf("failed to add step metadata: %w", err)
+		}
+	}
+
+	return s.metadataService.ClearJournal()
+}
+
+func (s *WorkspaceService) rollForwardFinish(ctx context.Context, repoPath string, repo git.Repository, journal *Journal) error {
+	trunk := journal.trunk()
+
+	trunkHash, err := repo.GetBranchHash(ctx, trunk)
+	if err != nil {
+		return fmt.Errorf("failed to get %s tip: %w", trunk, err)
+	}
+
+	// The squashed commit never landed, simply run the finish again
+	if trunkHash == journal.Refs[trunk] {
+		if err := s.metadataService.ClearJournal(); err != nil {
+			return err
+		}
+		if journal.Continue {
+			return s.ContinueFinish(ctx, repoPath)
+		}
+		return s.FinishWorkspace(ctx, repoPath, FinishOptions{Message: journal.Message})
+	}
+
+	open, err := s.openElsewhere(ctx, repo, trunk)
+	if err != nil {
+		return err
+	}
+	if open != "" {
+		if err := repo.DetachHead(ctx, trunkHash); err != nil {
+			return fmt.Errorf("failed to checkout %s: %w", trunk, err)
+		}
+	} else if err := repo.RestoreBranch(ctx, trunk, trunkHash, trunkHash); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", trunk, err)
+	}
+
+	if branchHash, err := repo.GetReference(ctx, "refs/heads/"+journal.branch()); err != nil {
+		return fmt.Errorf("failed to get workspace branch: %w", err)
+	} else if branchHash != "" {
+		if err := repo.DeleteBranch(ctx, journal.branch()); err != nil {
+			return fmt.Errorf("failed to delete workspace branch: %w", err)
+		}
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if _, archived := metadata.Archived[trunkHash]; !archived {
+		if err := s.completeFinish(ctx, repo, metadata, journal.Workspace, trunkHash, journal.StepCommits); err != nil {
+			return err
+		}
+	}
+
+	if journal.Continue {
+		if err := s.metadataService.ClearConflictState(); err != nil {
+			return fmt.Errorf("failed to clear conflict state: %w", err)
+		}
+	}
+
+	return s.metadataService.ClearJournal()
+}
+
+func (s *WorkspaceService) loadJournalInProgress() (*Journal, error) {
+	journal, err := s.metadataService.LoadJournal()
+	if err != nil {
+		return nil, err
+	}
+	if journal == nil {
+		return nil, fmt.Errorf("no interrupted operation to recover")
+	}
+	return journal, nil
+}
@@ -0,0 +1,124 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// WorkspaceStatus describes a workspace as seen by both the metadata and git.
+type WorkspaceStatus struct {
+	Name           string    `json:"name"`
+	Description    string    `json:"description"`
+	Current        bool      `json:"current"`
+	Steps          int       `json:"steps"`
+	CreatedAt      time.Time `json:"created_at,omitzero"`
+	Ahead          int       `json:"ahead"`
+	Behind         int       `json:"behind"`
+	BranchExists   bool      `json:"branch_exists"`
+	MetadataExists bool      `json:"metadata_exists"`
+	// Worktree is the path of the worktree the workspace is checked out in, if any.
+	Worktree string `json:"worktree,omitempty"`
+}
+
+// ListWorkspaces returns every workspace known to the metadata, plus branches
+// carrying work that is not on the trunk but have no metadata.
+func (s *WorkspaceService) ListWorkspaces(ctx context.Context, repoPath string) ([]WorkspaceStatus, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	branches, err := repo.ListBranches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	branchExists := make(map[string]bool, len(branches))
+	for _, branch := range branches {
+		branchExists[branch] = true
+	}
+
+	// A detached HEAD simply has no current workspace
+	current, head, _ := s.deriveWorkspace(ctx, repo, metadata)
+
+	worktrees, err := repo.ListWorktrees(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	openIn := make(map[string]string, len(worktrees))
+	for _, worktree := range worktrees {
+		if worktree.Branch != "" {
+			openIn[worktree.Branch] = worktree.Path
+		}
+	}
+
+	var statuses []WorkspaceStatus
+
+	for name, workspace := range metadata.Workspaces {
+		branch := config.WorkspaceBranch(name)
+		status := WorkspaceStatus{
+			Name:           name,
+			Description:    workspace.Description,
+			Current:        name == current,
+			Steps:          len(workspace.Steps),
+			CreatedAt:      workspace.CreatedAt,
+			BranchExists:   branchExists[branch],
+			MetadataExists: true,
+			Worktree:       openIn[branch],
+		}
+
+		if status.BranchExists {
+			status.Ahead, status.Behind, err = repo.AheadBehind(ctx, branch, config.Trunk)
+			if err != nil {
+				return nil, fmt.Errorf("failed to compare workspace '%s' with %s: %w", name, config.Trunk, err)
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	for _, branch := range branches {
+		name, ok := config.WorkspaceName(branch)
+		if !ok {
+			continue
+		}
+		if _, known := metadata.Workspaces[name]; known {
+			continue
+		}
+
+		ahead, behind, err := repo.AheadBehind(ctx, branch, config.Trunk)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare branch '%s' with %s: %w", branch, config.Trunk, err)
+		}
+
+		// Branches with nothing over the trunk (like the initial branch) are not lost work
+		if ahead == 0 {
+			continue
+		}
+
+		statuses = append(statuses, WorkspaceStatus{
+			Name:         name,
+			Current:      branch == head,
+			Ahead:        ahead,
+			Behind:       behind,
+			BranchExists: true,
+			Worktree:     openIn[branch],
+		})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+
+	return statuses, nil
+}
@@ -0,0 +1,232 @@
+package luna
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// LockInfo identifies the process holding the luna lock.
+type LockInfo struct {
+	PID        int       `json:"pid"`
+	Host       string    `json:"host"`
+	Command    string    `json:"command"`
+	AcquiredAt time.Time `json:"acquired_at"`
+}
+
+// LockedError is returned when another luna process holds the lock.
+type LockedError struct {
+	Holder LockInfo
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("another luna process is running (pid %d: %s) - if it is gone, run 'luna unlock'", e.Holder.PID, e.Holder.Command)
+}
+
+// Lock is an exclusive advisory lock on the luna state of a repository.
+type Lock struct {
+	path string
+}
+
+func (m *MetadataService) getLockPath() string {
+	return filepath.Join(m.commonDir(), "luna.lock")
+}
+
+// Lock takes the luna lock for the given command. A lock left behind by a process that is
+// no longer running on this host is broken automatically; any other lock fails with a
+// *LockedError.
+func (m *MetadataService) Lock(command string) (*Lock, error) {
+	host, _ := os.Hostname()
+
+	info := LockInfo{
+		PID:        os.Getpid(),
+		Host:       host,
+		Command:    command,
+		AcquiredAt: time.Now(),
+	}
+
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal lock: %w", err)
+	}
+
+	path := m.getLockPath()
+
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, writeErr := file.Write(data)
+			closeErr := file.Close()
+			if writeErr != nil || closeErr != nil {
+				os.Remove(path)
+				return nil, fmt.Errorf("failed to write lock: %w", errors.Join(writeErr, closeErr))
+			}
+			return &Lock{path: path}, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock: %w", err)
+		}
+
+		held, err := os.ReadFile(path)
+		// Released between our attempt and the read, simply try again
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lock: %w", err)
+		}
+
+		holder, err := m.parseLock(held)
+		if err != nil {
+			return nil, err
+		}
+
+		if !holder.stale(host) {
+			return nil, &LockedError{Holder: *holder}
+		}
+
+		if err := m.breakStaleLock(held); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("failed to acquire lock")
+}
+
+// LockHolder returns the process holding the lock, or nil if the repository is not locked.
+func (m *MetadataService) LockHolder() (*LockInfo, error) {
+	data, err := os.ReadFile(m.getLockPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lock: %w", err)
+	}
+
+	return m.parseLock(data)
+}
+
+// parseLock returns the holder recorded in the content of the lock.
+func (m *MetadataService) parseLock(data []byte) (*LockInfo, error) {
+	var info LockInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		// Either being written right now or cut short by a crash, tell them apart by age
+		stat, err := os.Stat(m.getLockPath())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read lock: %w", err)
+		}
+		return &LockInfo{AcquiredAt: stat.ModTime()}, nil
+	}
+
+	return &info, nil
+}
+
+// breakStaleLock removes the lock if it still is the stale one, held. Another process may
+// have broken it already and taken the lock since, so the lock is first moved aside
+// atomically, and put back if it turns out not to be the stale one.
+func (m *MetadataService) breakStaleLock(held []byte) error {
+	path := m.getLockPath()
+	aside := fmt.Sprintf("%s.stale-%d", path, os.Getpid())
+
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to break lock: %w", err)
+	}
+
+	moved, err := os.ReadFile(aside)
+	if err != nil {
+		return fmt.Errorf("failed to break lock: %w", err)
+	}
+
+	if !bytes.Equal(moved, held) {
+		// Link fails rather than replace a lock taken in the meantime
+		if err := os.Link(aside, path); err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to put back the lock of another process: %w", err)
+		}
+	}
+
+	if err := os.Remove(aside); err != nil {
+		return fmt.Errorf("failed to break lock: %w", err)
+	}
+	return nil
+}
+
+// BreakLock removes the lock whoever holds it.
+func (m *MetadataService) BreakLock() error {
+	if err := os.Remove(m.getLockPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove lock: %w", err)
+	}
+	return nil
+}
+
+// Unlock releases the lock.
+func (l *Lock) Unlock() error {
+	if err := os.Remove(l.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release lock: %w", err)
+	}
+	return nil
+}
+
+// Lock takes the luna lock of the repository for the duration of a command.
+func (s *WorkspaceService) Lock(command string) (*Lock, error) {
+	return s.metadataService.Lock(command)
+}
+
+// LockHolder returns the process holding the luna lock, or nil if there is none.
+func (s *WorkspaceService) LockHolder() (*LockInfo, error) {
+	return s.metadataService.LockHolder()
+}
+
+// BreakLock removes the luna lock. Unless force is set, it refuses while the holder
+// may still be running.
+func (s *WorkspaceService) BreakLock(force bool) error {
+	holder, err := s.metadataService.LockHolder()
+	if err != nil {
+		return err
+	}
+	if holder == nil {
+		return nil
+	}
+
+	host, _ := os.Hostname()
+	if !force && !holder.stale(host) {
+		return &LockedError{Holder: *holder}
+	}
+
+	return s.metadataService.BreakLock()
+}
+
+// stale reports whether the lock holder is known to be gone.
+func (i *LockInfo) stale(host string) bool {
+	if i.PID == 0 {
+		return time.Since(i.AcquiredAt) > 10*time.Second
+	}
+	return i.Host == host && !processAlive(i.PID)
+}
+
+// processAlive reports whether a process with the given pid is still running.
+// When it cannot tell it assumes the process is alive.
+func processAlive(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	if err == nil {
+		return true
+	}
+
+	return !errors.Is(err, os.ErrProcessDone) && !errors.Is(err, syscall.ESRCH)
+}
@@ -0,0 +1,158 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// LogStep is a step commit with the files it touched.
+type LogStep struct {
+	// Description is the description the step was created with.
+	Description string
+	Commit      git.CommitInfo
+	Stats       []git.FileStat
+	// Missing is set when the step commit is no longer in the repository.
+	Missing bool
+}
+
+// LogEntry is a commit on luna and, when it is a landed workspace, how it was built.
+type LogEntry struct {
+	Commit    git.CommitInfo
+	Workspace *ArchivedWorkspace
+	Steps     []LogStep
+	// Stats is only filled in by Show.
+	Stats []git.FileStat
+}
+
+// Log walks the trunk, newest first. A limit of 0 means no limit.
+func (s *WorkspaceService) Log(ctx context.Context, repoPath string, limit int) ([]LogEntry, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	commits, err := repo.Log(ctx, config.Trunk, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s history: %w", config.Trunk, err)
+	}
+
+	entries := make([]LogEntry, 0, len(commits))
+	for _, commit := range commits {
+		entry := LogEntry{Commit: commit}
+
+		if archived, exists := metadata.Archived[commit.Hash]; exists {
+			entry.Workspace = &archived
+			entry.Steps = loadLogSteps(ctx, repo, archived.Workspace.Steps, archived.StepCommits)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// Show returns a single commit and, when it is a landed workspace, the steps it was built from.
+func (s *WorkspaceService) Show(ctx context.Context, repoPath, revision string) (*LogEntry, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	hash, err := repo.ResolveRevision(ctx, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	commit, err := repo.GetCommit(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	stats, err := repo.DiffStat(ctx, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &LogEntry{Commit: *commit, Stats: stats}
+	if archived, exists := metadata.Archived[hash]; exists {
+		entry.Workspace = &archived
+		entry.Steps = loadLogSteps(ctx, repo, archived.Workspace.Steps, archived.StepCommits)
+	}
+
+	return entry, nil
+}
+
+// WorkspaceLog returns the step commits of the current workspace, oldest first.
+func (s *WorkspaceService) WorkspaceLog(ctx context.Context, repoPath string) (*WorkspaceMetadata, []LogStep, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	current, _, err := s.deriveWorkspace(ctx, repo, metadata)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workspace, exists := metadata.Workspaces[current]
+	if !exists {
+		return nil, nil, fmt.Errorf("no active workspace")
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	commits, err := repo.ListBranchCommits(ctx, config.WorkspaceBranch(current), config.Trunk)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to list workspace commits: %w", err)
+	}
+
+	return &workspace, loadLogSteps(ctx, repo, workspace.Steps, commits), nil
+}
+
+// loadLogSteps returns the steps with their commits. Workspaces recorded without steps
+// fall back to their commits, described by their messages.
+func loadLogSteps(ctx context.Context, repo git.Repository, steps []Step, hashes []string) []LogStep {
+	if len(steps) == 0 {
+		for _, hash := range hashes {
+			steps = append(steps, Step{CommitHash: hash})
+		}
+	}
+
+	logSteps := make([]LogStep, 0, len(steps))
+	for _, step := range steps {
+		commit, err := repo.GetCommit(ctx, step.CommitHash)
+		if err != nil {
+			logSteps = append(logSteps, LogStep{Description: step.Description, Commit: git.CommitInfo{Hash: step.CommitHash}, Missing: true})
+			continue
+		}
+
+		stats, err := repo.DiffStat(ctx, step.CommitHash)
+		if err != nil {
+			stats = nil
+		}
+
+		description := step.Description
+		if description == "" {
+			description = commit.Subject()
+		}
+
+		logSteps = append(logSteps, LogStep{Description: description, Commit: *commit, Stats: stats})
+	}
+	return logSteps
+}
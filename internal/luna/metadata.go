@@ -3,9 +3,10 @@ package luna
 import (
 	"encoding/json"
 	"fmt"
-	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/okzmo/luna/internal/git"
 )
 
 type WorkspaceMetadata struct {
@@ -21,70 +22,118 @@ type Step struct {
 	CreatedAt   time.Time `json:"created_at"`
 }
 
+// DroppedWorkspace is an abandoned workspace kept recoverable until ExpiresAt.
+type DroppedWorkspace struct {
+	Workspace  WorkspaceMetadata `json:"workspace"`
+	BranchHash string            `json:"branch_hash"`
+	WIPHash    string            `json:"wip_hash,omitempty"`
+	DroppedAt  time.Time         `json:"dropped_at"`
+	ExpiresAt  time.Time         `json:"expires_at"`
+}
+
+// ArchivedWorkspace is a landed workspace, keyed by its squashed commit on luna.
+type ArchivedWorkspace struct {
+	Workspace   WorkspaceMetadata `json:"workspace"`
+	StepCommits []string          `json:"step_commits"`
+	ArchiveRef  string            `json:"archive_ref,omitempty"`
+	LandedAt    time.Time         `json:"landed_at"`
+}
+
 type LunaMetadata struct {
+	Version          int                          `json:"version"`
 	Workspaces       map[string]WorkspaceMetadata `json:"workspaces"`
 	CurrentWorkspace string                       `json:"current_workspace"`
+	Dropped          map[string]DroppedWorkspace  `json:"dropped,omitempty"`
+	Archived         map[string]ArchivedWorkspace `json:"archived,omitempty"`
 }
 
 type MetadataService struct {
 	repoPath string
+	// repo reads the backend config and stores metadata in refs; nil always uses the file backend.
+	repo git.Repository
 }
 
-func NewMetadataService(repoPath string) *MetadataService {
+func NewMetadataService(repoPath string, repo git.Repository) *MetadataService {
 	return &MetadataService{
 		repoPath: repoPath,
+		repo:     repo,
 	}
 }
 
-func (m *MetadataService) getMetadataPath() string {
-	return filepath.Join(m.repoPath, ".git", "metadata.json")
+// gitDir is where the state of this worktree lives: journal, conflict and operation log.
+func (m *MetadataService) gitDir() string {
+	gitDir, _, err := git.ResolveGitDirs(m.repoPath)
+	if err != nil {
+		return filepath.Join(m.repoPath, ".git")
+	}
+	return gitDir
+}
+
+// commonDir is where the state shared by every worktree of the repository lives: metadata,
+// its backups and the lock.
+func (m *MetadataService) commonDir() string {
+	_, commonDir, err := git.ResolveGitDirs(m.repoPath)
+	if err != nil {
+		return filepath.Join(m.repoPath, ".git")
+	}
+	return commonDir
 }
 
 func (m *MetadataService) LoadMetadata() (*LunaMetadata, error) {
-	metadataPath := m.getMetadataPath()
+	backend, err := m.backend()
+	if err != nil {
+		return nil, err
+	}
 
-	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+	data, err := backend.read()
+	if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
 		return &LunaMetadata{
+			Version:          CurrentMetadataVersion,
 			Workspaces:       make(map[string]WorkspaceMetadata),
 			CurrentWorkspace: "",
+			Dropped:          make(map[string]DroppedWorkspace),
+			Archived:         make(map[string]ArchivedWorkspace),
 		}, nil
 	}
 
-	data, err := os.ReadFile(metadataPath)
+	data, err = m.migrateMetadata(backend, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+		return nil, err
 	}
 
 	var metadata LunaMetadata
 	if err := json.Unmarshal(data, &metadata); err != nil {
-		return nil, fmt.Errorf("failed to parse metadata file: %w", err)
+		return nil, &CorruptMetadataError{Path: backend.path(), Err: err}
 	}
 
 	if metadata.Workspaces == nil {
 		metadata.Workspaces = make(map[string]WorkspaceMetadata)
 	}
 
+	if metadata.Dropped == nil {
+		metadata.Dropped = make(map[string]DroppedWorkspace)
+	}
+
+	if metadata.Archived == nil {
+		metadata.Archived = make(map[string]ArchivedWorkspace)
+	}
+
 	return &metadata, nil
 }
 
 func (m *MetadataService) SaveMetadata(metadata *LunaMetadata) error {
-	metadataPath := m.getMetadataPath()
-
-	gitDir := filepath.Dir(metadataPath)
-	if err := os.MkdirAll(gitDir, 0755); err != nil {
-		return fmt.Errorf("failed to create .git directory: %w", err)
-	}
+	metadata.Version = CurrentMetadataVersion
 
 	data, err := json.MarshalIndent(metadata, "", "  ")
 	if err != nil {
 		return fmt.Errorf("failed to marshal metadata: %w", err)
 	}
 
-	if err := os.WriteFile(metadataPath, data, 0644); err != nil {
-		return fmt.Errorf("failed to write metadata file: %w", err)
-	}
-
-	return nil
+	return m.writeMetadata(data)
 }
 
 func (m *MetadataService) CreateWorkspace(name, description string) error {
@@ -110,19 +159,15 @@ func (m *MetadataService) CreateWorkspace(name, description string) error {
 	return m.SaveMetadata(metadata)
 }
 
-func (m *MetadataService) AddStep(description, commitHash string) error {
+func (m *MetadataService) AddStep(workspaceName, description, commitHash string) error {
 	metadata, err := m.LoadMetadata()
 	if err != nil {
 		return fmt.Errorf("failed to load metadata: %w", err)
 	}
 
-	if metadata.CurrentWorkspace == "" {
-		return fmt.Errorf("no active workspace")
-	}
-
-	workspace, exists := metadata.Workspaces[metadata.CurrentWorkspace]
+	workspace, exists := metadata.Workspaces[workspaceName]
 	if !exists {
-		return fmt.Errorf("current workspace '%s' not found", metadata.CurrentWorkspace)
+		return fmt.Errorf("workspace '%s' not found", workspaceName)
 	}
 
 	step := Step{
@@ -132,11 +177,13 @@ func (m *MetadataService) AddStep(description, commitHash string) error {
 	}
 
 	workspace.Steps = append(workspace.Steps, step)
-	metadata.Workspaces[metadata.CurrentWorkspace] = workspace
+	metadata.Workspaces[workspaceName] = workspace
 
 	return m.SaveMetadata(metadata)
 }
 
+// GetCurrentWorkspace returns the cached current workspace; WorkspaceService.CurrentWorkspace
+// derives the actual one from HEAD.
 func (m *MetadataService) GetCurrentWorkspace() (string, error) {
 	metadata, err := m.LoadMetadata()
 	if err != nil {
@@ -0,0 +1,320 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// Metadata backends, selected by the luna.metadata repository config.
+const (
+	// MetadataBackendFile keeps all metadata in .git/metadata.json.
+	MetadataBackendFile = "file"
+	// MetadataBackendRefs keeps each workspace under refs/luna/meta/<workspace> so it travels
+	// with fetch and push; state only meaningful to this clone stays in .git/metadata.local.json.
+	MetadataBackendRefs = "refs"
+)
+
+// MetadataBackendConfigKey is the repository config key selecting the metadata backend.
+const MetadataBackendConfigKey = "luna.metadata"
+
+// metaRefFile is the file holding a workspace in the tree of its metadata reference.
+const metaRefFile = "workspace.json"
+
+// metadataBackend stores the metadata document, as LunaMetadata JSON.
+type metadataBackend interface {
+	// read returns the document, or nil if there is none yet.
+	read() ([]byte, error)
+	write(data []byte) error
+	remove() error
+	// path is the local file of the backend, where corruption is reported.
+	path() string
+}
+
+type fileMetadataBackend struct {
+	file string
+}
+
+func (b *fileMetadataBackend) read() ([]byte, error) {
+	data, err := os.ReadFile(b.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metadata file: %w", err)
+	}
+	return data, nil
+}
+
+func (b *fileMetadataBackend) write(data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(b.file), 0755); err != nil {
+		return fmt.Errorf("failed to create .git directory: %w", err)
+	}
+	if err := writeFileAtomic(b.file, data); err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+	return nil
+}
+
+func (b *fileMetadataBackend) remove() error {
+	if err := os.Remove(b.file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove metadata file: %w", err)
+	}
+	return nil
+}
+
+func (b *fileMetadataBackend) path() string {
+	return b.file
+}
+
+// refsMetadataBackend splits the document: workspaces go to their own reference, the
+// rest to a local file.
+type refsMetadataBackend struct {
+	repo  git.Repository
+	local *fileMetadataBackend
+}
+
+func (b *refsMetadataBackend) read() ([]byte, error) {
+	ctx := context.Background()
+
+	local, err := b.local.read()
+	if err != nil {
+		return nil, err
+	}
+
+	refs, err := b.repo.ListReferences(ctx, git.MetaRefPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list metadata references: %w", err)
+	}
+
+	if local == nil && len(refs) == 0 {
+		return nil, nil
+	}
+
+	doc := make(map[string]json.RawMessage)
+	if local != nil {
+		if err := json.Unmarshal(local, &doc); err != nil {
+			return nil, &CorruptMetadataError{Path: b.local.file, Err: err}
+		}
+	}
+
+	workspaces := make(map[string]json.RawMessage, len(refs))
+	for ref := range refs {
+		data, err := b.repo.ReadRefFile(ctx, ref, metaRefFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read workspace metadata: %w", err)
+		}
+		if data == nil || !json.Valid(data) {
+			return nil, &CorruptMetadataError{Path: ref, Err: fmt.Errorf("invalid %s", metaRefFile)}
+		}
+		workspaces[strings.TrimPrefix(ref, git.MetaRefPrefix)] = data
+	}
+
+	encoded, err := json.Marshal(workspaces)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal workspaces: %w", err)
+	}
+	doc["workspaces"] = encoded
+
+	data, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	return data, nil
+}
+
+func (b *refsMetadataBackend) write(data []byte) error {
+	ctx := context.Background()
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("failed to parse metadata: %w", err)
+	}
+
+	workspaces := make(map[string]json.RawMessage)
+	if raw, ok := doc["workspaces"]; ok && string(raw) != "null" {
+		if err := json.Unmarshal(raw, &workspaces); err != nil {
+			return fmt.Errorf("failed to parse workspaces: %w", err)
+		}
+	}
+	delete(doc, "workspaces")
+
+	existing, err := b.repo.ListReferences(ctx, git.MetaRefPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list metadata references: %w", err)
+	}
+
+	names := make([]string, 0, len(workspaces))
+	for name := range workspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, workspaces[name], "", "  "); err != nil {
+			return fmt.Errorf("failed to format workspace '%s': %w", name, err)
+		}
+
+		ref := git.MetaRefPrefix + name
+		current, err := b.repo.ReadRefFile(ctx, ref, metaRefFile)
+		if err != nil {
+			return fmt.Errorf("failed to read workspace metadata: %w", err)
+		}
+		if bytes.Equal(current, buf.Bytes()) {
+			continue
+		}
+
+		if err := b.repo.WriteRefFile(ctx, ref, metaRefFile, buf.Bytes(), fmt.Sprintf("luna: update workspace %s", name)); err != nil {
+			return fmt.Errorf("failed to write workspace metadata: %w", err)
+		}
+	}
+
+	for ref := range existing {
+		if _, keep := workspaces[strings.TrimPrefix(ref, git.MetaRefPrefix)]; keep {
+			continue
+		}
+		if err := b.repo.RemoveReference(ctx, ref); err != nil {
+			return fmt.Errorf("failed to remove workspace metadata: %w", err)
+		}
+	}
+
+	local, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	return b.local.write(local)
+}
+
+func (b *refsMetadataBackend) remove() error {
+	ctx := context.Background()
+
+	refs, err := b.repo.ListReferences(ctx, git.MetaRefPrefix)
+	if err != nil {
+		return fmt.Errorf("failed to list metadata references: %w", err)
+	}
+	for ref := range refs {
+		if err := b.repo.RemoveReference(ctx, ref); err != nil {
+			return fmt.Errorf("failed to remove workspace metadata: %w", err)
+		}
+	}
+
+	return b.local.remove()
+}
+
+func (b *refsMetadataBackend) path() string {
+	return b.local.file
+}
+
+func (m *MetadataService) newBackend(name string) (metadataBackend, error) {
+	gitDir := m.commonDir()
+
+	switch name {
+	case "", MetadataBackendFile:
+		return &fileMetadataBackend{file: filepath.Join(gitDir, "metadata.json")}, nil
+	case MetadataBackendRefs:
+		if m.repo == nil {
+			return nil, fmt.Errorf("the refs metadata backend needs a git repository")
+		}
+		return &refsMetadataBackend{
+			repo:  m.repo,
+			local: &fileMetadataBackend{file: filepath.Join(gitDir, "metadata.local.json")},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown metadata backend '%s' (expected '%s' or '%s')", name, MetadataBackendFile, MetadataBackendRefs)
+	}
+}
+
+// backendName returns the configured metadata backend.
+func (m *MetadataService) backendName() (string, error) {
+	if m.repo == nil {
+		return MetadataBackendFile, nil
+	}
+
+	// Before init there is no config to read, and nothing but the file backend makes sense
+	if _, _, err := git.ResolveGitDirs(m.repoPath); os.IsNotExist(err) {
+		return MetadataBackendFile, nil
+	}
+
+	name, err := m.repo.GetConfig(context.Background(), MetadataBackendConfigKey)
+	if err != nil {
+		return "", fmt.Errorf("failed to read metadata backend config: %w", err)
+	}
+	if name == "" {
+		return MetadataBackendFile, nil
+	}
+
+	return name, nil
+}
+
+func (m *MetadataService) backend() (metadataBackend, error) {
+	name, err := m.backendName()
+	if err != nil {
+		return nil, err
+	}
+	return m.newBackend(name)
+}
+
+// MigrateBackend moves the metadata to the given backend and selects it in the repository
+// config. The previous storage is removed, its content kept in the metadata backups.
+func (m *MetadataService) MigrateBackend(target string) error {
+	current, err := m.backendName()
+	if err != nil {
+		return err
+	}
+	if current == target {
+		return fmt.Errorf("metadata is already stored in the '%s' backend", target)
+	}
+
+	to, err := m.newBackend(target)
+	if err != nil {
+		return err
+	}
+	from, err := m.newBackend(current)
+	if err != nil {
+		return err
+	}
+
+	metadata, err := m.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata: %w", err)
+	}
+
+	if err := m.rotateBackups(data); err != nil {
+		return err
+	}
+
+	if err := to.write(data); err != nil {
+		return err
+	}
+
+	if err := m.repo.SetConfig(context.Background(), MetadataBackendConfigKey, target); err != nil {
+		return fmt.Errorf("failed to select metadata backend: %w", err)
+	}
+
+	return from.remove()
+}
+
+// MetadataBackend returns the metadata backend the repository is configured with.
+func (s *WorkspaceService) MetadataBackend() (string, error) {
+	return s.metadataService.backendName()
+}
+
+// MigrateMetadataBackend moves the metadata to the given backend.
+func (s *WorkspaceService) MigrateMetadataBackend(target string) error {
+	return s.metadataService.MigrateBackend(target)
+}
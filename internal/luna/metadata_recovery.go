@@ -0,0 +1,325 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// MetadataBackupCount is how many previous versions of metadata.json are kept.
+const MetadataBackupCount = 5
+
+// CorruptMetadataError is returned when metadata.json exists but cannot be parsed.
+type CorruptMetadataError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptMetadataError) Error() string {
+	return fmt.Sprintf("metadata file %s is corrupted (%v) - run 'luna recover --from-backup' or 'luna recover --rebuild-metadata'", e.Path, e.Err)
+}
+
+func (e *CorruptMetadataError) Unwrap() error {
+	return e.Err
+}
+
+// MetadataBackup is a previous good version of metadata.json, 1 being the most recent.
+type MetadataBackup struct {
+	Index      int       `json:"index"`
+	Path       string    `json:"path"`
+	SavedAt    time.Time `json:"saved_at"`
+	Workspaces int       `json:"workspaces"`
+}
+
+func (m *MetadataService) getBackupDir() string {
+	return filepath.Join(m.commonDir(), "luna-backups")
+}
+
+func (m *MetadataService) getBackupPath(index int) string {
+	return filepath.Join(m.getBackupDir(), fmt.Sprintf("metadata.json.%d", index))
+}
+
+// writeMetadata atomically replaces the metadata document, first rotating the version it
+// replaces into the backups if it is a good one.
+func (m *MetadataService) writeMetadata(data []byte) error {
+	backend, err := m.backend()
+	if err != nil {
+		return err
+	}
+
+	if current, err := backend.read(); err == nil && current != nil && !bytes.Equal(current, data) {
+		if err := m.rotateBackups(current); err != nil {
+			return err
+		}
+	}
+
+	return backend.write(data)
+}
+
+func (m *MetadataService) rotateBackups(data []byte) error {
+	var metadata LunaMetadata
+	if err := json.Unmarshal(data, &metadata); err != nil {
+		// Never push a good backup out for a corrupted one
+		return nil
+	}
+
+	if latest, err := os.ReadFile(m.getBackupPath(1)); err == nil && bytes.Equal(latest, data) {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.getBackupDir(), 0755); err != nil {
+		return fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	for i := MetadataBackupCount - 1; i >= 1; i-- {
+		if err := os.Rename(m.getBackupPath(i), m.getBackupPath(i+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate metadata backups: %w", err)
+		}
+	}
+
+	if err := writeFileAtomic(m.getBackupPath(1), data); err != nil {
+		return fmt.Errorf("failed to back up metadata: %w", err)
+	}
+
+	return nil
+}
+
+// MetadataBackups returns the backups that can be restored, most recent first.
+func (m *MetadataService) MetadataBackups() ([]MetadataBackup, error) {
+	var backups []MetadataBackup
+
+	for i := 1; i <= MetadataBackupCount; i++ {
+		path := m.getBackupPath(i)
+
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata backup: %w", err)
+		}
+
+		var metadata LunaMetadata
+		if err := json.Unmarshal(data, &metadata); err != nil {
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read metadata backup: %w", err)
+		}
+
+		backups = append(backups, MetadataBackup{
+			Index:      i,
+			Path:       path,
+			SavedAt:    info.ModTime(),
+			Workspaces: len(metadata.Workspaces),
+		})
+	}
+
+	return backups, nil
+}
+
+// RestoreMetadataBackup replaces metadata.json with the given backup. A corrupted
+// metadata.json is kept next to it as metadata.json.corrupt.
+func (m *MetadataService) RestoreMetadataBackup(index int) error {
+	data, err := os.ReadFile(m.getBackupPath(index))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("metadata backup %d not found", index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read metadata backup: %w", err)
+	}
+
+	if !json.Valid(data) {
+		return fmt.Errorf("metadata backup %d is corrupted", index)
+	}
+
+	if err := m.setAsideCorruptMetadata(); err != nil {
+		return err
+	}
+
+	return m.writeMetadata(data)
+}
+
+func (m *MetadataService) setAsideCorruptMetadata() error {
+	backend, err := m.backend()
+	if err != nil {
+		return err
+	}
+	metadataPath := backend.path()
+
+	data, err := os.ReadFile(metadataPath)
+	if os.IsNotExist(err) || json.Valid(data) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read metadata file: %w", err)
+	}
+
+	if err := os.Rename(metadataPath, metadataPath+".corrupt"); err != nil {
+		return fmt.Errorf("failed to set aside corrupted metadata: %w", err)
+	}
+
+	return nil
+}
+
+// RestoreMetadataBackup replaces metadata.json with the given backup, 1 being the most recent.
+func (s *WorkspaceService) RestoreMetadataBackup(index int) error {
+	return s.metadataService.RestoreMetadataBackup(index)
+}
+
+// MetadataBackups returns the backups of metadata.json that can be restored, most recent first.
+func (s *WorkspaceService) MetadataBackups() ([]MetadataBackup, error) {
+	return s.metadataService.MetadataBackups()
+}
+
+// CheckMetadata returns a *CorruptMetadataError if metadata.json cannot be parsed.
+func (s *WorkspaceService) CheckMetadata() error {
+	_, err := s.metadataService.LoadMetadata()
+	return err
+}
+
+// RebuildMetadata recreates metadata.json from the branches and hidden luna references.
+// Workspaces are the branches carrying commits over the trunk (and the checked out branch),
+// with one step per commit, their descriptions recovered from the commit messages.
+// Dropped workspaces come back from their dropped references without their steps, and
+// landed workspace history is not rebuilt.
+func (s *WorkspaceService) RebuildMetadata(ctx context.Context, repoPath string) (*LunaMetadata, error) {
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	metadata := &LunaMetadata{
+		Workspaces: make(map[string]WorkspaceMetadata),
+		Dropped:    make(map[string]DroppedWorkspace),
+		Archived:   make(map[string]ArchivedWorkspace),
+	}
+
+	currentBranch, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	branches, err := repo.ListBranches(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	for _, branch := range branches {
+		name, ok := config.WorkspaceName(branch)
+		if !ok {
+			continue
+		}
+
+		workspace, err := rebuildWorkspace(ctx, repo, config, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(workspace.Steps) == 0 && branch != currentBranch {
+			continue
+		}
+
+		metadata.Workspaces[name] = *workspace
+		if branch == currentBranch {
+			metadata.CurrentWorkspace = name
+		}
+	}
+
+	dropped, err := repo.ListReferences(ctx, git.DroppedRefPrefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dropped workspaces: %w", err)
+	}
+
+	for ref, hash := range dropped {
+		key := strings.TrimPrefix(ref, git.DroppedRefPrefix)
+
+		// A workspace dropped again while the first drop was kept is under name@<n>
+		name := key
+		if i := strings.LastIndex(key, "@"); i > 0 {
+			if _, err := strconv.Atoi(key[i+1:]); err == nil {
+				name = key[:i]
+			}
+		}
+
+		now := time.Now()
+		metadata.Dropped[key] = DroppedWorkspace{
+			Workspace: WorkspaceMetadata{
+				Name:        name,
+				Description: name,
+				CreatedAt:   now,
+				Steps:       []Step{},
+			},
+			BranchHash: hash,
+			DroppedAt:  now,
+			ExpiresAt:  now.Add(DefaultDropRetention),
+		}
+	}
+
+	if err := s.metadataService.setAsideCorruptMetadata(); err != nil {
+		return nil, err
+	}
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to save metadata: %w", err)
+	}
+
+	return metadata, nil
+}
+
+// rebuildWorkspace recovers a workspace from the commits of its branch over the trunk. Each
+// step commit carries the description of the step before it, the first one the workspace's.
+func rebuildWorkspace(ctx context.Context, repo git.Repository, config *Config, name string) (*WorkspaceMetadata, error) {
+	commits, err := repo.ListBranchCommits(ctx, config.WorkspaceBranch(name), config.Trunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits of '%s': %w", name, err)
+	}
+
+	workspace := &WorkspaceMetadata{
+		Name:        name,
+		Description: name,
+		Steps:       []Step{},
+	}
+
+	infos := make([]*git.CommitInfo, 0, len(commits))
+	for _, hash := range commits {
+		info, err := repo.GetCommit(ctx, hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get commit %s: %w", hash, err)
+		}
+		infos = append(infos, info)
+	}
+
+	for i, info := range infos {
+		description := "Recovered step"
+		if i+1 < len(infos) {
+			description = infos[i+1].Subject()
+		}
+
+		workspace.Steps = append(workspace.Steps, Step{
+			Description: description,
+			CommitHash:  info.Hash,
+			CreatedAt:   info.When,
+		})
+	}
+
+	if len(infos) > 0 {
+		workspace.Description = infos[0].Subject()
+		workspace.CreatedAt = infos[0].When
+	} else {
+		workspace.CreatedAt = time.Now()
+	}
+
+	return workspace, nil
+}
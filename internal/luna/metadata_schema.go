@@ -0,0 +1,114 @@
+package luna
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// CurrentMetadataVersion is the metadata.json schema this binary reads and writes.
+const CurrentMetadataVersion = 1
+
+// metadataMigration upgrades the raw metadata document from version From to From+1.
+type metadataMigration struct {
+	From        int
+	Description string
+	Migrate     func(doc map[string]json.RawMessage) error
+}
+
+// metadataMigrations must hold one migration per version below CurrentMetadataVersion,
+// in order. Files written before versioning have no version field and are version 0.
+var metadataMigrations = []metadataMigration{
+	{
+		From:        0,
+		Description: "add schema version",
+		Migrate: func(doc map[string]json.RawMessage) error {
+			if _, ok := doc["workspaces"]; !ok {
+				doc["workspaces"] = json.RawMessage("{}")
+			}
+			return nil
+		},
+	},
+}
+
+// NewerSchemaError is returned when metadata.json was written by a newer luna.
+type NewerSchemaError struct {
+	Found     int
+	Supported int
+}
+
+func (e *NewerSchemaError) Error() string {
+	return fmt.Sprintf("metadata schema version %d is newer than the version %d this luna supports - upgrade luna", e.Found, e.Supported)
+}
+
+func (m *MetadataService) getMigrationBackupPath(version int) string {
+	return filepath.Join(m.getBackupDir(), fmt.Sprintf("metadata.v%d.json", version))
+}
+
+// migrateMetadata returns data upgraded to CurrentMetadataVersion. When an upgrade is needed,
+// the original file is kept in the backups and the upgraded one written in its place.
+func (m *MetadataService) migrateMetadata(backend metadataBackend, data []byte) ([]byte, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, &CorruptMetadataError{Path: backend.path(), Err: err}
+	}
+
+	version := 0
+	if raw, ok := doc["version"]; ok {
+		if err := json.Unmarshal(raw, &version); err != nil {
+			return nil, &CorruptMetadataError{Path: backend.path(), Err: fmt.Errorf("invalid version: %w", err)}
+		}
+	}
+
+	if version > CurrentMetadataVersion {
+		return nil, &NewerSchemaError{Found: version, Supported: CurrentMetadataVersion}
+	}
+	if version == CurrentMetadataVersion {
+		return data, nil
+	}
+
+	originalVersion := version
+
+	for _, migration := range metadataMigrations {
+		if migration.From < version {
+			continue
+		}
+		if migration.From != version {
+			return nil, fmt.Errorf("no metadata migration from version %d", version)
+		}
+
+		if err := migration.Migrate(doc); err != nil {
+			return nil, fmt.Errorf("failed to migrate metadata from version %d (%s): %w", version, migration.Description, err)
+		}
+		version++
+	}
+
+	if version != CurrentMetadataVersion {
+		return nil, fmt.Errorf("no metadata migration from version %d", version)
+	}
+
+	doc["version"] = json.RawMessage(fmt.Sprint(version))
+
+	migrated, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal migrated metadata: %w", err)
+	}
+
+	if err := os.MkdirAll(m.getBackupDir(), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory: %w", err)
+	}
+
+	backupPath := m.getMigrationBackupPath(originalVersion)
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		if err := writeFileAtomic(backupPath, data); err != nil {
+			return nil, fmt.Errorf("failed to back up metadata before migration: %w", err)
+		}
+	}
+
+	if err := backend.write(migrated); err != nil {
+		return nil, fmt.Errorf("failed to write migrated metadata: %w", err)
+	}
+
+	return migrated, nil
+}
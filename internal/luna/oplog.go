@@ -0,0 +1,588 @@
+package luna
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// RepoState is everything luna needs to put a repository back to a given point.
+type RepoState struct {
+	// Head is the checked out branch, empty before the repository was initialized.
+	Head string `json:"head"`
+	// Detached is the commit HEAD points to when it is not on a branch.
+	Detached string `json:"detached,omitempty"`
+	// Refs holds every branch and hidden luna reference.
+	Refs map[string]string `json:"refs"`
+	// Worktree is a commit holding the uncommitted changes, empty if the worktree was clean.
+	Worktree      string          `json:"worktree,omitempty"`
+	Metadata      json.RawMessage `json:"metadata"`
+	ConflictState json.RawMessage `json:"conflict_state,omitempty"`
+}
+
+// Operation is one luna command that changed the repository.
+type Operation struct {
+	ID      int       `json:"id"`
+	Command string    `json:"command"`
+	Time    time.Time `json:"time"`
+	Before  RepoState `json:"before"`
+	After   RepoState `json:"after"`
+}
+
+// RefChange describes how an operation moved a reference; an empty hash means it did not exist.
+type RefChange struct {
+	Ref    string `json:"ref"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// OpLogSize is how many operations the operation log keeps. Once it holds twice as many
+// the oldest are forgotten and can no longer be undone or restored.
+const OpLogSize = 100
+
+// stateRefPrefixes are the references an operation snapshot covers.
+var stateRefPrefixes = []string{"refs/heads/", "refs/luna/"}
+
+// getOpLogPath is the operation log, one operation per line so recording one only appends.
+func (m *MetadataService) getOpLogPath() string {
+	return filepath.Join(m.gitDir(), "op_log.jsonl")
+}
+
+// getLegacyOpLogPath is the operation log as a single JSON array, as older versions wrote it.
+func (m *MetadataService) getLegacyOpLogPath() string {
+	return filepath.Join(m.gitDir(), "op_log.json")
+}
+
+// opRefPrefix is where the worktree snapshots of this worktree's operations are anchored.
+// Every worktree numbers its operations on its own, so linked worktrees get their own
+// namespace.
+func (m *MetadataService) opRefPrefix() string {
+	gitDir, commonDir, err := git.ResolveGitDirs(m.repoPath)
+	if err != nil || filepath.Clean(gitDir) == filepath.Clean(commonDir) {
+		return git.OpRefPrefix
+	}
+	return git.OpRefPrefix + "worktrees/" + filepath.Base(gitDir) + "/"
+}
+
+// LoadOpLog returns the recorded operations, oldest first.
+func (m *MetadataService) LoadOpLog() ([]Operation, error) {
+	data, err := os.ReadFile(m.getOpLogPath())
+	if os.IsNotExist(err) {
+		return m.loadLegacyOpLog()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+
+	return parseOpLog(data), nil
+}
+
+func (m *MetadataService) loadLegacyOpLog() ([]Operation, error) {
+	data, err := os.ReadFile(m.getLegacyOpLogPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+
+	var operations []Operation
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return nil, fmt.Errorf("failed to parse operation log: %w", err)
+	}
+
+	return operations, nil
+}
+
+// opLogRecord is an operation as the log stores it. The metadata before an operation is
+// most often what the previous one left, it is then not written a second time.
+type opLogRecord struct {
+	Operation
+	BeforeMetadataUnchanged bool `json:"before_metadata_unchanged,omitempty"`
+}
+
+// encodeOperation returns the log line of an operation recorded after previous, nil for the
+// first one.
+func encodeOperation(previous *Operation, operation Operation) ([]byte, error) {
+	record := opLogRecord{Operation: operation}
+	if previous != nil && operation.Before.Metadata != nil && sameJSON(operation.Before.Metadata, previous.After.Metadata) {
+		record.Before.Metadata = nil
+		record.BeforeMetadataUnchanged = true
+	}
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal operation: %w", err)
+	}
+	return append(line, '\n'), nil
+}
+
+// sameJSON reports whether two JSON documents only differ in whitespace, as the metadata
+// read from disk does from the metadata read back from the log.
+func sameJSON(a, b []byte) bool {
+	var compactA, compactB bytes.Buffer
+	if json.Compact(&compactA, a) != nil || json.Compact(&compactB, b) != nil {
+		return false
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes())
+}
+
+// parseOpLog reads one operation per line. A line cut short by a crash while it was
+// appended is skipped.
+func parseOpLog(data []byte) []Operation {
+	var operations []Operation
+
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var record opLogRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			continue
+		}
+		if record.BeforeMetadataUnchanged && len(operations) > 0 {
+			record.Before.Metadata = operations[len(operations)-1].After.Metadata
+		}
+		operations = append(operations, record.Operation)
+	}
+
+	return operations
+}
+
+// lastOperation returns the last complete operation in the log, nil if there is none. Only
+// what it left is complete: the state before it may refer to the operation before.
+func lastOperation(data []byte) *Operation {
+	lines := bytes.Split(bytes.TrimRight(data, "\n"), []byte("\n"))
+	for i := len(lines) - 1; i >= 0; i-- {
+		var record opLogRecord
+		if err := json.Unmarshal(lines[i], &record); err == nil {
+			return &record.Operation
+		}
+	}
+	return nil
+}
+
+// SaveOpLog replaces the operation log with the given operations.
+func (m *MetadataService) SaveOpLog(operations []Operation) error {
+	var data bytes.Buffer
+	for i, operation := range operations {
+		var previous *Operation
+		if i > 0 {
+			previous = &operations[i-1]
+		}
+
+		line, err := encodeOperation(previous, operation)
+		if err != nil {
+			return err
+		}
+		data.Write(line)
+	}
+
+	if err := writeFileAtomic(m.getOpLogPath(), data.Bytes()); err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+
+	if err := os.Remove(m.getLegacyOpLogPath()); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove old operation log: %w", err)
+	}
+
+	return nil
+}
+
+// appendOperation adds an operation at the end of the log, after data, the log as it is
+// on disk.
+func (m *MetadataService) appendOperation(data []byte, operation Operation) error {
+	line, err := encodeOperation(lastOperation(data), operation)
+	if err != nil {
+		return err
+	}
+
+	// Never glue the operation to a line cut short by a crash
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		line = append([]byte("\n"), line...)
+	}
+
+	f, err := os.OpenFile(m.getOpLogPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open operation log: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(line); err != nil {
+		return fmt.Errorf("failed to append to operation log: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync operation log: %w", err)
+	}
+
+	return nil
+}
+
+// readRawConflictState returns conflict_state.json as stored on disk, or nil if it does not exist.
+func (m *MetadataService) readRawConflictState() ([]byte, error) {
+	data, err := os.ReadFile(m.getConflictStatePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read conflict state: %w", err)
+	}
+	return data, nil
+}
+
+// writeRawConflictState puts back conflict_state.json exactly as returned by readRawConflictState.
+func (m *MetadataService) writeRawConflictState(data []byte) error {
+	if data == nil {
+		return m.ClearConflictState()
+	}
+
+	if err := writeFileAtomic(m.getConflictStatePath(), data); err != nil {
+		return fmt.Errorf("failed to write conflict state: %w", err)
+	}
+	return nil
+}
+
+// SnapshotState captures the current state of the repository. Outside of a repository
+// it returns an empty state.
+func (s *WorkspaceService) SnapshotState(ctx context.Context, repoPath string) (*RepoState, error) {
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	state := &RepoState{Refs: make(map[string]string)}
+
+	isRepo, err := repo.IsRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check repository: %w", err)
+	}
+	if !isRepo {
+		return state, nil
+	}
+
+	if state.Head, err = repo.GetCurrentBranch(ctx); err != nil {
+		if state.Detached, err = repo.ResolveRevision(ctx, "HEAD"); err != nil {
+			return nil, fmt.Errorf("failed to resolve HEAD: %w", err)
+		}
+	}
+
+	for _, prefix := range stateRefPrefixes {
+		refs, err := repo.ListReferences(ctx, prefix)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list references: %w", err)
+		}
+		for name, hash := range refs {
+			// The anchors of the operation log are not part of the state it restores
+			if strings.HasPrefix(name, git.OpRefPrefix) {
+				continue
+			}
+			state.Refs[name] = hash
+		}
+	}
+
+	state.Worktree, err = repo.SnapshotWorktree(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to snapshot worktree: %w", err)
+	}
+
+	if state.Metadata, err = s.metadataService.readRawMetadata(); err != nil {
+		return nil, err
+	}
+	if state.ConflictState, err = s.metadataService.readRawConflictState(); err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// RecordOperation appends a command to the operation log if it changed the repository
+// since the before snapshot. It returns the recorded operation, or nil if nothing changed.
+func (s *WorkspaceService) RecordOperation(ctx context.Context, repoPath, command string, before *RepoState) (*Operation, error) {
+	after, err := s.SnapshotState(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Still not a repository, there is nowhere to record anything
+	if (after.Head == "" && after.Detached == "") || sameState(before, after) {
+		return nil, nil
+	}
+
+	if err := s.migrateOpLog(ctx, repoPath); err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(s.metadataService.getOpLogPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+
+	id := 1
+	if last := lastOperation(data); last != nil {
+		id = last.ID + 1
+	}
+
+	operation := Operation{
+		ID:      id,
+		Command: command,
+		Time:    time.Now(),
+		Before:  *before,
+		After:   *after,
+	}
+
+	if err := s.anchorOperation(ctx, repoPath, &operation); err != nil {
+		return nil, err
+	}
+
+	if err := s.metadataService.appendOperation(data, operation); err != nil {
+		return nil, err
+	}
+
+	if bytes.Count(data, []byte("\n")) >= 2*OpLogSize {
+		if err := s.pruneOpLog(ctx, repoPath); err != nil {
+			return nil, err
+		}
+	}
+
+	return &operation, nil
+}
+
+// anchorOperation keeps the worktree snapshots of an operation reachable, they are on no
+// branch and git gc would otherwise prune them.
+func (s *WorkspaceService) anchorOperation(ctx context.Context, repoPath string, operation *Operation) error {
+	repo := s.gitFactory.NewRepository(repoPath)
+	prefix := fmt.Sprintf("%s%d/", s.metadataService.opRefPrefix(), operation.ID)
+
+	for name, hash := range map[string]string{"before": operation.Before.Worktree, "after": operation.After.Worktree} {
+		if hash == "" {
+			continue
+		}
+		if _, err := repo.GetCommit(ctx, hash); err != nil {
+			// Already pruned, there is nothing left to keep
+			continue
+		}
+		if err := repo.SetReference(ctx, prefix+name, hash); err != nil {
+			return fmt.Errorf("failed to anchor worktree snapshot: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// pruneOpLog forgets all but the last OpLogSize operations and releases their snapshots.
+func (s *WorkspaceService) pruneOpLog(ctx context.Context, repoPath string) error {
+	operations, err := s.metadataService.LoadOpLog()
+	if err != nil {
+		return err
+	}
+	if len(operations) <= OpLogSize {
+		return nil
+	}
+
+	pruned, kept := operations[:len(operations)-OpLogSize], operations[len(operations)-OpLogSize:]
+	if err := s.metadataService.SaveOpLog(kept); err != nil {
+		return err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+	prefix := s.metadataService.opRefPrefix()
+	for _, operation := range pruned {
+		for _, name := range []string{"before", "after"} {
+			if err := repo.RemoveReference(ctx, fmt.Sprintf("%s%d/%s", prefix, operation.ID, name)); err != nil {
+				return fmt.Errorf("failed to release worktree snapshot: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// migrateOpLog rewrites an operation log left as a JSON array by an older version one
+// operation per line, anchoring the snapshots it still can.
+func (s *WorkspaceService) migrateOpLog(ctx context.Context, repoPath string) error {
+	if _, err := os.Stat(s.metadataService.getLegacyOpLogPath()); os.IsNotExist(err) {
+		return nil
+	}
+
+	operations, err := s.metadataService.LoadOpLog()
+	if err != nil {
+		return err
+	}
+
+	for i := range operations {
+		if err := s.anchorOperation(ctx, repoPath, &operations[i]); err != nil {
+			return err
+		}
+	}
+
+	if err := s.metadataService.SaveOpLog(operations); err != nil {
+		return err
+	}
+
+	if len(operations) > 2*OpLogSize {
+		return s.pruneOpLog(ctx, repoPath)
+	}
+	return nil
+}
+
+// Operations returns the operation log, most recent first.
+func (s *WorkspaceService) Operations() ([]Operation, error) {
+	operations, err := s.metadataService.LoadOpLog()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, j := 0, len(operations)-1; i < j; i, j = i+1, j-1 {
+		operations[i], operations[j] = operations[j], operations[i]
+	}
+
+	return operations, nil
+}
+
+// Undo puts the repository back to how it was before the last operation and returns
+// that operation, with the paths where the uncommitted changes kept conflict with the
+// restored branch. Undoing an undo redoes the operation it undid.
+func (s *WorkspaceService) Undo(ctx context.Context, repoPath string, restoreWorktree bool) (*Operation, []string, error) {
+	operations, err := s.metadataService.LoadOpLog()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(operations) == 0 {
+		return nil, nil, fmt.Errorf("no operation to undo")
+	}
+
+	last := operations[len(operations)-1]
+	conflicts, err := s.restoreState(ctx, repoPath, &last.Before, restoreWorktree)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &last, conflicts, nil
+}
+
+// RestoreOperation puts the repository back to how it was right after the given operation,
+// and returns the paths where the uncommitted changes kept conflict with the restored branch.
+func (s *WorkspaceService) RestoreOperation(ctx context.Context, repoPath string, id int, restoreWorktree bool) (*Operation, []string, error) {
+	operations, err := s.metadataService.LoadOpLog()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, operation := range operations {
+		if operation.ID == id {
+			conflicts, err := s.restoreState(ctx, repoPath, &operation.After, restoreWorktree)
+			if err != nil {
+				return nil, nil, err
+			}
+			return &operation, conflicts, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("operation %d not found", id)
+}
+
+// restoreState moves every reference and the metadata back to the given state. Uncommitted
+// changes are merged onto the restored branch, and the paths where they conflict returned,
+// unless restoreWorktree is set or the worktree is clean, in which case the files recorded
+// with the state come back.
+func (s *WorkspaceService) restoreState(ctx context.Context, repoPath string, state *RepoState, restoreWorktree bool) ([]string, error) {
+	if state.Detached != "" {
+		return nil, fmt.Errorf("cannot restore a state where HEAD was detached")
+	}
+	if state.Head == "" {
+		return nil, fmt.Errorf("cannot restore to before the repository was initialized")
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	current, err := s.SnapshotState(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	tipHash := state.Refs["refs/heads/"+state.Head]
+	worktreeHash := state.Worktree
+	if worktreeHash == "" {
+		worktreeHash = tipHash
+	}
+
+	// Only the uncommitted changes move over, not the files of the branch they were made on
+	var conflicts []string
+	if current.Worktree != "" && !restoreWorktree {
+		if worktreeHash, conflicts, err = repo.CarrySnapshot(ctx, current.Worktree, tipHash); err != nil {
+			return nil, err
+		}
+	}
+
+	for name := range current.Refs {
+		if _, keep := state.Refs[name]; keep {
+			continue
+		}
+		if err := repo.RemoveReference(ctx, name); err != nil {
+			return nil, fmt.Errorf("failed to remove reference %s: %w", name, err)
+		}
+	}
+
+	for name, hash := range state.Refs {
+		if current.Refs[name] == hash {
+			continue
+		}
+		if err := repo.SetReference(ctx, name, hash); err != nil {
+			return nil, fmt.Errorf("failed to restore reference %s: %w", name, err)
+		}
+	}
+
+	if err := repo.RestoreBranch(ctx, state.Head, tipHash, worktreeHash); err != nil {
+		return nil, fmt.Errorf("failed to restore worktree: %w", err)
+	}
+
+	if err := s.metadataService.writeRawMetadata(state.Metadata); err != nil {
+		return nil, err
+	}
+
+	if err := s.metadataService.writeRawConflictState(state.ConflictState); err != nil {
+		return nil, err
+	}
+
+	return conflicts, nil
+}
+
+// RefChanges lists the references the operation created, moved or deleted, sorted by name.
+func (o *Operation) RefChanges() []RefChange {
+	var changes []RefChange
+
+	for name, before := range o.Before.Refs {
+		if after := o.After.Refs[name]; after != before {
+			changes = append(changes, RefChange{Ref: name, Before: before, After: after})
+		}
+	}
+	for name, after := range o.After.Refs {
+		if _, existed := o.Before.Refs[name]; !existed {
+			changes = append(changes, RefChange{Ref: name, After: after})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Ref < changes[j].Ref
+	})
+
+	return changes
+}
+
+func sameState(a, b *RepoState) bool {
+	if a.Head != b.Head || a.Detached != b.Detached || a.Worktree != b.Worktree || len(a.Refs) != len(b.Refs) {
+		return false
+	}
+	for name, hash := range a.Refs {
+		if b.Refs[name] != hash {
+			return false
+		}
+	}
+	return bytes.Equal(a.Metadata, b.Metadata) && bytes.Equal(a.ConflictState, b.ConflictState)
+}
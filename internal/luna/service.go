@@ -4,6 +4,7 @@ package luna
 import (
 	"context"
 	"fmt"
+	"os"
 
 	"github.com/okzmo/luna/internal/git"
 )
@@ -19,9 +20,25 @@ func NewInitService(gitFactory git.RepositoryFactory) *InitService {
 }
 
 func (s *InitService) InitRepository(ctx context.Context, path string) error {
-	repo := s.gitFactory.NewRepository(path)
+	configPath := path
+	if configPath == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current working directory: %w", err)
+		}
+		configPath = wd
+	}
+
+	// A .luna/config.toml written before init picks the trunk and the first message
+	configService := NewConfigService(configPath, nil)
+	config, err := configService.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	repo := git.WithIdentity(s.gitFactory, configService.Identity).NewRepository(path)
 
-	if err := repo.Init(ctx, path); err != nil {
+	if err := repo.Init(ctx, path, config.Trunk, config.InitialMessage); err != nil {
 		return fmt.Errorf("failed to initialize git repository: %w", err)
 	}
 
@@ -0,0 +1,194 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v6/plumbing/object"
+	"github.com/okzmo/luna/internal/git"
+)
+
+// defaultSquashTemplate lists the steps and ends with the trailers: the workspace landed
+// and everyone who authored a step of it.
+const defaultSquashTemplate = `{{.Description}}
+{{if .Steps}}
+{{range .Steps}}- {{.Description}}
+{{end}}{{end}}
+Luna-Workspace: {{.Workspace}}
+{{range .CoAuthors}}Co-authored-by: {{.}}
+{{end}}{{if .Signoff}}Signed-off-by: {{.Committer}}
+{{end}}`
+
+// SquashMessageData is what messages.squash_template is rendered with, as a Go text/template.
+type SquashMessageData struct {
+	Workspace   string
+	Description string
+	Trunk       string
+	// Steps are the steps of the workspace, oldest first.
+	Steps []SquashMessageStep
+	// CoAuthors are the distinct authors of the steps other than the author of the
+	// squashed commit, as "Name <email>".
+	CoAuthors []string
+	// Committer is the committer of the squashed commit, as "Name <email>".
+	Committer string
+	// Signoff is set by 'luna ws done --signoff'.
+	Signoff bool
+}
+
+// SquashMessageStep is a step as the squash template sees it.
+type SquashMessageStep struct {
+	Description string
+	// Author is the author of the step commit, "" when it is no longer on the branch.
+	Author string
+}
+
+// FinishOptions controls the message of the squashed commit a finish lands.
+type FinishOptions struct {
+	// Message replaces the one rendered from the squash template.
+	Message string
+	// Signoff adds a Signed-off-by trailer for the committer to the rendered message.
+	Signoff bool
+	// Edit reviews the message before anything changes, nil to land it as is. An error
+	// cancels the finish.
+	Edit func(message string) (string, error)
+}
+
+// squashMessage returns the message the workspace lands with.
+func (s *WorkspaceService) squashMessage(ctx context.Context, repoPath string, repo git.Repository, config *Config, name string, workspace WorkspaceMetadata, options FinishOptions) (string, error) {
+	message := options.Message
+
+	if message == "" {
+		data, err := s.squashMessageData(ctx, repo, config, name, workspace)
+		if err != nil {
+			return "", err
+		}
+		data.Signoff = options.Signoff
+
+		if message, err = renderSquashMessage(repoPath, config.SquashTemplate, data); err != nil {
+			return "", err
+		}
+	}
+
+	if options.Edit != nil {
+		edited, err := options.Edit(message)
+		if err != nil {
+			return "", err
+		}
+		message = edited
+	}
+
+	message = cleanupMessage(message)
+	if message == "" {
+		return "", fmt.Errorf("empty message, the workspace was not landed")
+	}
+
+	return message, nil
+}
+
+func (s *WorkspaceService) squashMessageData(ctx context.Context, repo git.Repository, config *Config, name string, workspace WorkspaceMetadata) (*SquashMessageData, error) {
+	author, committer, err := repo.GetSignatures()
+	if err != nil {
+		return nil, err
+	}
+
+	data := &SquashMessageData{
+		Workspace:   name,
+		Description: workspace.Description,
+		Trunk:       config.Trunk,
+		Committer:   formatIdentity(committer),
+	}
+
+	hashes, err := repo.ListBranchCommits(ctx, config.WorkspaceBranch(name), config.Trunk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list workspace commits: %w", err)
+	}
+
+	authors := make(map[string]string, len(hashes))
+	seen := map[string]bool{strings.ToLower(author.Email): true}
+	for _, hash := range hashes {
+		commit, err := repo.GetCommit(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		commitAuthor := fmt.Sprintf("%s <%s>", commit.AuthorName, commit.AuthorEmail)
+		authors[hash] = commitAuthor
+
+		if email := strings.ToLower(commit.AuthorEmail); !seen[email] {
+			seen[email] = true
+			data.CoAuthors = append(data.CoAuthors, commitAuthor)
+		}
+	}
+
+	for _, step := range workspace.Steps {
+		data.Steps = append(data.Steps, SquashMessageStep{Description: step.Description, Author: authors[step.CommitHash]})
+	}
+
+	return data, nil
+}
+
+// renderSquashMessage renders the template file at path, or the built-in template when
+// path is empty. A relative path is relative to the repository root.
+func renderSquashMessage(repoPath, path string, data *SquashMessageData) (string, error) {
+	text := defaultSquashTemplate
+	name := "built-in"
+
+	if path != "" {
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, rest)
+			}
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(repoPath, path)
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", ConfigSquashTemplate, err)
+		}
+		text, name = string(content), path
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid squash template %s: %w", name, err)
+	}
+
+	var message strings.Builder
+	if err := tmpl.Execute(&message, data); err != nil {
+		return "", fmt.Errorf("failed to render squash template %s: %w", name, err)
+	}
+
+	return message.String(), nil
+}
+
+// cleanupMessage trims trailing spaces, runs of blank lines and the blank lines around
+// the message, as git does with the messages it commits.
+func cleanupMessage(message string) string {
+	var lines []string
+	blank := false
+
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" {
+			blank = len(lines) > 0
+			continue
+		}
+		if blank {
+			lines = append(lines, "")
+			blank = false
+		}
+		lines = append(lines, line)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func formatIdentity(signature *object.Signature) string {
+	return fmt.Sprintf("%s <%s>", signature.Name, signature.Email)
+}
@@ -0,0 +1,80 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// StatusReport describes where the user is and what changed since the last step.
+type StatusReport struct {
+	Branch      string
+	Workspace   string
+	Description string
+	CurrentStep string
+	Changes     *git.WorktreeStatus
+	Conflict    *ConflictState
+	Warnings    []string
+}
+
+// Status reports the current workspace, the step being worked on and the
+// changes made since the last step commit.
+func (s *WorkspaceService) Status(ctx context.Context, repoPath string) (*StatusReport, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	report := &StatusReport{}
+
+	report.Workspace, report.Branch, err = s.deriveWorkspace(ctx, repo, metadata)
+	if err != nil {
+		report.Warnings = append(report.Warnings, "HEAD is not on a branch")
+	}
+
+	report.Changes, err = repo.Status(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get worktree status: %w", err)
+	}
+
+	report.Conflict, err = s.metadataService.LoadConflictState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conflict state: %w", err)
+	}
+
+	if workspace, exists := metadata.Workspaces[report.Workspace]; exists {
+		report.Description = workspace.Description
+		if len(workspace.Steps) > 0 {
+			report.CurrentStep = workspace.Steps[len(workspace.Steps)-1].Description
+		} else {
+			report.CurrentStep = workspace.Description
+		}
+	}
+
+	switch {
+	case report.Branch == "":
+	case report.Workspace == "" && report.Branch != config.Trunk:
+		report.Warnings = append(report.Warnings, fmt.Sprintf("HEAD is on '%s' which is not a luna workspace - switch with 'luna ws switch <name>'", report.Branch))
+	case report.Branch == config.Trunk && !report.Changes.IsClean():
+		report.Warnings = append(report.Warnings, fmt.Sprintf("uncommitted changes directly on %s - create a workspace with 'luna ws <name> <description>'", config.Trunk))
+	}
+
+	// HEAD moved without luna, e.g. with git checkout: the recorded workspace is stale
+	if report.Branch != "" && metadata.CurrentWorkspace != report.Workspace {
+		recorded := fmt.Sprintf("the current workspace is recorded as '%s'", metadata.CurrentWorkspace)
+		if metadata.CurrentWorkspace == "" {
+			recorded = "no current workspace is recorded"
+		}
+		report.Warnings = append(report.Warnings, fmt.Sprintf("HEAD is on '%s' but %s - run 'luna doctor --fix'", report.Branch, recorded))
+	}
+
+	return report, nil
+}
@@ -0,0 +1,106 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// StepSelection limits a step to part of the changes. Whatever is left out stays in the
+// worktree and goes into a later step.
+type StepSelection struct {
+	// Only keeps the files matching one of these globs, all files when empty.
+	Only []string
+	// Exclude drops the files matching one of these globs.
+	Exclude []string
+	// Dir is the directory the globs are relative to, slash separated from the repository
+	// root; the root when empty.
+	Dir string
+	// Pick narrows diff.Hunks down to the hunks to commit and reports whether anything of
+	// the file is committed; binary files, which have no hunks, go whole or not at all.
+	// Without Pick every change of the files kept is committed.
+	Pick func(diff *git.FileDiff) (bool, error)
+}
+
+// CreatePartialStep creates a step out of the changes picked by selection.
+func (s *WorkspaceService) CreatePartialStep(ctx context.Context, repoPath, description string, selection StepSelection) error {
+	return s.createStep(ctx, repoPath, description, &selection)
+}
+
+func (s *WorkspaceService) stageSelection(ctx context.Context, repo git.Repository, selection *StepSelection) error {
+	diffs, err := repo.WorktreeDiff(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to diff worktree: %w", err)
+	}
+
+	var selected []git.FileDiff
+	for _, diff := range diffs {
+		if !selection.selects(diff.Path) {
+			continue
+		}
+
+		if selection.Pick != nil {
+			keep, err := selection.Pick(&diff)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				continue
+			}
+		}
+
+		selected = append(selected, diff)
+	}
+
+	if len(selected) == 0 {
+		return fmt.Errorf("no changes selected")
+	}
+
+	if err := repo.StageDiffs(ctx, selected); err != nil {
+		return fmt.Errorf("failed to stage changes: %w", err)
+	}
+
+	return nil
+}
+
+// selects reports whether the globs keep a file: it matches Only, when set, and nothing of
+// Exclude, which wins over Only.
+func (s *StepSelection) selects(file string) bool {
+	if len(s.Only) > 0 && !matchesAny(s.Only, s.Dir, file) {
+		return false
+	}
+	return !matchesAny(s.Exclude, s.Dir, file)
+}
+
+// matchesAny reports whether a slash separated path matches one of the globs, taken
+// relative to dir. A glob matching a directory matches everything below it, and a glob
+// without a slash is also tried against the file name, so "*.go" matches Go files at any
+// depth below dir.
+func matchesAny(globs []string, dir, file string) bool {
+	dir = path.Clean("/" + dir)[1:]
+
+	for _, glob := range globs {
+		glob = strings.TrimSuffix(strings.TrimPrefix(glob, "./"), "/")
+
+		if !strings.Contains(glob, "/") && (dir == "" || strings.HasPrefix(file, dir+"/")) {
+			if ok, _ := path.Match(glob, path.Base(file)); ok {
+				return true
+			}
+		}
+
+		glob = path.Join(dir, glob)
+		if glob == "." {
+			return true
+		}
+
+		for d := file; d != "." && d != "/"; d = path.Dir(d) {
+			if ok, _ := path.Match(glob, d); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
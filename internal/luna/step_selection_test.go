@@ -0,0 +1,94 @@
+package luna
+
+import "testing"
+
+func TestMatchesAny(t *testing.T) {
+	tests := []struct {
+		name  string
+		globs []string
+		dir   string
+		file  string
+		want  bool
+	}{
+		{name: "exact path", globs: []string{"cmd/root.go"}, file: "cmd/root.go", want: true},
+		{name: "other path", globs: []string{"cmd/root.go"}, file: "cmd/log.go", want: false},
+		{name: "directory", globs: []string{"internal"}, file: "internal/luna/step.go", want: true},
+		{name: "directory with a trailing slash", globs: []string{"internal/"}, file: "internal/luna/step.go", want: true},
+		{name: "directory glob", globs: []string{"internal/*"}, file: "internal/luna/step.go", want: true},
+		{name: "directory glob elsewhere", globs: []string{"internal/*"}, file: "cmd/root.go", want: false},
+		{name: "directory name prefix", globs: []string{"int"}, file: "internal/luna/step.go", want: false},
+		{name: "slash-less glob at the root", globs: []string{"*.go"}, file: "main.go", want: true},
+		{name: "slash-less glob at any depth", globs: []string{"*.go"}, file: "internal/luna/step.go", want: true},
+		{name: "slash-less glob on another extension", globs: []string{"*.go"}, file: "docs/readme.md", want: false},
+		{name: "glob with a slash is anchored", globs: []string{"luna/*.go"}, file: "internal/luna/step.go", want: false},
+		{name: "dot slash prefix", globs: []string{"./cmd/root.go"}, file: "cmd/root.go", want: true},
+		{name: "dot slash directory", globs: []string{"./cmd"}, file: "cmd/root.go", want: true},
+		{name: "dot matches everything", globs: []string{"."}, file: "internal/luna/step.go", want: true},
+		{name: "any of several globs", globs: []string{"*.md", "cmd"}, file: "cmd/root.go", want: true},
+		{name: "no globs", file: "cmd/root.go", want: false},
+		{name: "relative to dir", globs: []string{"root.go"}, dir: "cmd", file: "cmd/root.go", want: true},
+		{name: "relative to dir with a dot slash", globs: []string{"./root.go"}, dir: "cmd", file: "cmd/root.go", want: true},
+		{name: "dot is dir", globs: []string{"."}, dir: "internal/luna", file: "internal/luna/step.go", want: true},
+		{name: "dot outside dir", globs: []string{"."}, dir: "internal/luna", file: "internal/git/replay.go", want: false},
+		{name: "slash-less glob below dir", globs: []string{"*.go"}, dir: "internal", file: "internal/luna/step.go", want: true},
+		{name: "slash-less glob outside dir", globs: []string{"*.go"}, dir: "internal", file: "cmd/root.go", want: false},
+		{name: "slash-less glob outside a dir sharing its prefix", globs: []string{"*.go"}, dir: "cmd", file: "cmdline/main.go", want: false},
+		{name: "parent of dir", globs: []string{"../git"}, dir: "internal/luna", file: "internal/git/replay.go", want: true},
+		{name: "unclean dir", globs: []string{"step.go"}, dir: "./internal//luna/", file: "internal/luna/step.go", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := matchesAny(tt.globs, tt.dir, tt.file); got != tt.want {
+				t.Fatalf("matchesAny(%q, %q, %q) = %v, want %v", tt.globs, tt.dir, tt.file, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStepSelectionSelects(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection StepSelection
+		file      string
+		want      bool
+	}{
+		{name: "no globs", file: "cmd/root.go", want: true},
+		{name: "only matching", selection: StepSelection{Only: []string{"cmd"}}, file: "cmd/root.go", want: true},
+		{name: "only not matching", selection: StepSelection{Only: []string{"cmd"}}, file: "main.go", want: false},
+		{name: "excluded", selection: StepSelection{Exclude: []string{"*_test.go"}}, file: "cmd/root_test.go", want: false},
+		{name: "not excluded", selection: StepSelection{Exclude: []string{"*_test.go"}}, file: "cmd/root.go", want: true},
+		{
+			name:      "exclude wins over only",
+			selection: StepSelection{Only: []string{"cmd"}, Exclude: []string{"*_test.go"}},
+			file:      "cmd/root_test.go",
+			want:      false,
+		},
+		{
+			name:      "exclude wins over the same glob in only",
+			selection: StepSelection{Only: []string{"cmd/root.go"}, Exclude: []string{"cmd/root.go"}},
+			file:      "cmd/root.go",
+			want:      false,
+		},
+		{
+			name:      "globs relative to dir",
+			selection: StepSelection{Only: []string{"."}, Exclude: []string{"log.go"}, Dir: "cmd"},
+			file:      "cmd/root.go",
+			want:      true,
+		},
+		{
+			name:      "exclude relative to dir",
+			selection: StepSelection{Only: []string{"."}, Exclude: []string{"log.go"}, Dir: "cmd"},
+			file:      "cmd/log.go",
+			want:      false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.selection.selects(tt.file); got != tt.want {
+				t.Fatalf("selects(%q) = %v, want %v", tt.file, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// SwitchResult reports what happened to uncommitted work during a switch.
+type SwitchResult struct {
+	SavedWIP    bool
+	RestoredWIP bool
+	Conflicts   []string
+}
+
+// SwitchWorkspace moves to another workspace, parking the uncommitted changes of the
+// current one and bringing back the ones left in the target. Switching to the trunk,
+// by its name or as "luna", leaves every workspace.
+func (s *WorkspaceService) SwitchWorkspace(ctx context.Context, repoPath, name string) (*SwitchResult, error) {
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	toTrunk := name == config.Trunk || name == "luna"
+	if _, exists := metadata.Workspaces[name]; !exists && !toTrunk {
+		return nil, fmt.Errorf("workspace '%s' not found", name)
+	}
+
+	branch := config.WorkspaceBranch(name)
+	if toTrunk {
+		branch = config.Trunk
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	currentBranch, err := repo.GetCurrentBranch(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
+	}
+
+	if currentBranch == branch {
+		return nil, fmt.Errorf("already on '%s'", name)
+	}
+
+	if !toTrunk {
+		if err := s.ensureNotOpenElsewhere(ctx, repo, name); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &SwitchResult{}
+
+	result.SavedWIP, err = repo.SaveWIP(ctx, currentBranch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save uncommitted changes: %w", err)
+	}
+
+	if err := repo.SwitchBranch(ctx, branch); err != nil {
+		// Still on the current branch, its changes go back where they were
+		if !result.SavedWIP {
+			return nil, fmt.Errorf("failed to switch to '%s': %w", name, err)
+		}
+		if _, _, restoreErr := repo.RestoreWIP(ctx, currentBranch); restoreErr != nil {
+			return nil, fmt.Errorf("failed to switch to '%s': %w (uncommitted changes are kept in %s%s: %v)", name, err, git.WIPRefPrefix, currentBranch, restoreErr)
+		}
+		return nil, fmt.Errorf("failed to switch to '%s': %w", name, err)
+	}
+
+	result.RestoredWIP, result.Conflicts, err = repo.RestoreWIP(ctx, branch)
+	if err != nil {
+		return nil, fmt.Errorf("failed to restore uncommitted changes: %w", err)
+	}
+
+	if toTrunk {
+		metadata.CurrentWorkspace = ""
+	} else {
+		metadata.CurrentWorkspace = name
+	}
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return nil, fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return result, nil
+}
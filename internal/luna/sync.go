@@ -0,0 +1,261 @@
+package luna
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// SyncWorkspace replays the steps of a workspace onto the current trunk tip.
+// An empty name syncs the current workspace.
+func (s *WorkspaceService) SyncWorkspace(ctx context.Context, repoPath, name string) error {
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return err
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if name == "" {
+		if name, _, err = s.deriveWorkspace(ctx, repo, metadata); err != nil {
+			return err
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("no active workspace")
+	}
+	if _, exists := metadata.Workspaces[name]; !exists {
+		return fmt.Errorf("workspace '%s' not found", name)
+	}
+
+	if err := s.ensureNotOpenElsewhere(ctx, repo, name); err != nil {
+		return err
+	}
+
+	clean, err := repo.IsClean(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check worktree: %w", err)
+	}
+	if !clean {
+		return fmt.Errorf("worktree has uncommitted changes - create a step with 'luna new' before syncing")
+	}
+
+	return s.syncWorkspace(ctx, repo, metadata, name)
+}
+
+// SyncAllWorkspaces syncs every workspace in name order, stopping at the first conflict.
+// Workspaces open in another worktree are left to be synced from there.
+// It returns the names of the workspaces synced.
+func (s *WorkspaceService) SyncAllWorkspaces(ctx context.Context, repoPath string) ([]string, error) {
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return nil, err
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	clean, err := repo.IsClean(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check worktree: %w", err)
+	}
+	if !clean {
+		return nil, fmt.Errorf("worktree has uncommitted changes - create a step with 'luna new' before syncing")
+	}
+
+	names := make([]string, 0, len(metadata.Workspaces))
+	for name := range metadata.Workspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	config, err := s.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	var synced []string
+	for _, name := range names {
+		open, err := s.openElsewhere(ctx, repo, config.WorkspaceBranch(name))
+		if err != nil {
+			return synced, err
+		}
+		if open != "" {
+			continue
+		}
+
+		if err := s.syncWorkspace(ctx, repo, metadata, name); err != nil {
+			return synced, err
+		}
+		synced = append(synced, name)
+	}
+
+	return synced, nil
+}
+
+func (s *WorkspaceService) syncWorkspace(ctx context.Context, repo git.Repository, metadata *LunaMetadata, name string) error {
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+	branch := config.WorkspaceBranch(name)
+
+	originalHash, err := repo.GetBranchHash(ctx, branch)
+	if err != nil {
+		return fmt.Errorf("failed to get workspace tip: %w", err)
+	}
+
+	trunkHash, err := repo.GetBranchHash(ctx, config.Trunk)
+	if err != nil {
+		return fmt.Errorf("failed to get %s tip: %w", config.Trunk, err)
+	}
+
+	commits, err := repo.ListBranchCommits(ctx, branch, config.Trunk)
+	if err != nil {
+		return fmt.Errorf("failed to list workspace commits: %w", err)
+	}
+
+	rewritten, err := repo.ReplayCommits(ctx, branch, config.Trunk, trunkHash, commits)
+	if err != nil {
+		var conflictErr *git.ConflictError
+		if errors.As(err, &conflictErr) {
+			return s.saveSyncConflict(metadata, name, config.Trunk, originalHash, commits, rewritten, conflictErr)
+		}
+		return fmt.Errorf("failed to sync workspace '%s': %w", name, err)
+	}
+
+	return s.completeSync(metadata, name, rewritten)
+}
+
+// ContinueSync resumes a sync stopped on conflicts once every path is resolved.
+func (s *WorkspaceService) ContinueSync(ctx context.Context, repoPath string) error {
+	state, err := s.loadConflictInProgress(OperationSync)
+	if err != nil {
+		return err
+	}
+
+	if unresolved := state.UnresolvedPaths(); len(unresolved) > 0 {
+		return fmt.Errorf("%d file(s) still unresolved - mark them with 'luna resolve <path>'", len(unresolved))
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if err := repo.StageAll(ctx); err != nil {
+		return fmt.Errorf("failed to stage resolved files: %w", err)
+	}
+
+	if state.RewrittenHashes == nil {
+		state.RewrittenHashes = make(map[string]string)
+	}
+
+	conflicted := state.PendingCommits[0]
+	resolvedHash, err := repo.CommitResolved(ctx, conflicted, state.TargetHash)
+	if err != nil {
+		return fmt.Errorf("failed to commit resolved step: %w", err)
+	}
+	state.RewrittenHashes[conflicted] = resolvedHash
+
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	rewritten, err := repo.ReplayCommits(ctx, config.WorkspaceBranch(state.Workspace), state.TargetBranch, resolvedHash, state.PendingCommits[1:])
+	for oldHash, newHash := range rewritten {
+		state.RewrittenHashes[oldHash] = newHash
+	}
+	if err != nil {
+		var conflictErr *git.ConflictError
+		if errors.As(err, &conflictErr) {
+			return s.saveSyncConflict(metadata, state.Workspace, state.TargetBranch, state.OriginalHash, state.PendingCommits[1:], state.RewrittenHashes, conflictErr)
+		}
+		return fmt.Errorf("failed to sync workspace '%s': %w", state.Workspace, err)
+	}
+
+	if err := s.completeSync(metadata, state.Workspace, state.RewrittenHashes); err != nil {
+		return err
+	}
+
+	if err := s.metadataService.ClearConflictState(); err != nil {
+		return fmt.Errorf("failed to clear conflict state: %w", err)
+	}
+
+	return nil
+}
+
+// AbortSync restores the workspace branch as it was before the sync started.
+func (s *WorkspaceService) AbortSync(ctx context.Context, repoPath string) error {
+	return s.abortConflict(ctx, repoPath, OperationSync)
+}
+
+func (s *WorkspaceService) saveSyncConflict(metadata *LunaMetadata, name, trunk, originalHash string, commits []string, rewritten map[string]string, conflictErr *git.ConflictError) error {
+	pending := commits
+	for i, hash := range commits {
+		if hash == conflictErr.Commit {
+			pending = commits[i:]
+			break
+		}
+	}
+
+	if rewritten == nil {
+		rewritten = make(map[string]string)
+	}
+
+	state := &ConflictState{
+		Operation:       OperationSync,
+		Workspace:       name,
+		Description:     metadata.Workspaces[name].Description,
+		TargetBranch:    trunk,
+		TargetHash:      conflictErr.TargetHash,
+		OriginalHash:    originalHash,
+		WorktreeHash:    originalHash,
+		ConflictedPaths: conflictErr.Paths,
+		ResolvedPaths:   []string{},
+		StartedAt:       time.Now(),
+		PendingCommits:  pending,
+		RewrittenHashes: rewritten,
+	}
+	if err := s.metadataService.SaveConflictState(state); err != nil {
+		return fmt.Errorf("failed to save conflict state: %w", err)
+	}
+
+	// The conflicted workspace is now checked out
+	metadata.CurrentWorkspace = name
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return conflictErr
+}
+
+func (s *WorkspaceService) completeSync(metadata *LunaMetadata, name string, rewritten map[string]string) error {
+	workspace := metadata.Workspaces[name]
+	for i, step := range workspace.Steps {
+		if newHash, ok := rewritten[step.CommitHash]; ok {
+			workspace.Steps[i].CommitHash = newHash
+		}
+	}
+	metadata.Workspaces[name] = workspace
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return nil
+}
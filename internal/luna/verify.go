@@ -0,0 +1,56 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// VerifyEntry is a commit and the outcome of checking its signature.
+type VerifyEntry struct {
+	Commit git.CommitInfo
+	Check  git.SignatureCheck
+}
+
+// Verify checks the signatures of the given revisions, or of the trunk history, newest
+// first, when there are none. A limit of 0 means no limit.
+func (s *WorkspaceService) Verify(ctx context.Context, repoPath string, revisions []string, limit int) ([]VerifyEntry, error) {
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	var commits []git.CommitInfo
+	if len(revisions) == 0 {
+		config, err := s.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		if commits, err = repo.Log(ctx, config.Trunk, limit); err != nil {
+			return nil, fmt.Errorf("failed to read %s history: %w", config.Trunk, err)
+		}
+	}
+
+	for _, revision := range revisions {
+		hash, err := repo.ResolveRevision(ctx, revision)
+		if err != nil {
+			return nil, err
+		}
+
+		commit, err := repo.GetCommit(ctx, hash)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, *commit)
+	}
+
+	entries := make([]VerifyEntry, 0, len(commits))
+	for _, commit := range commits {
+		check, err := repo.VerifyCommit(ctx, commit.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s: %w", commit.Hash[:7], err)
+		}
+		entries = append(entries, VerifyEntry{Commit: commit, Check: *check})
+	}
+
+	return entries, nil
+}
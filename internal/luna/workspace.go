@@ -1,8 +1,12 @@
 package luna
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/okzmo/luna/internal/git"
@@ -11,16 +15,32 @@ import (
 type WorkspaceService struct {
 	gitFactory      git.RepositoryFactory
 	metadataService *MetadataService
+	configService   *ConfigService
 }
 
 func NewWorkspaceService(gitFactory git.RepositoryFactory, repoPath string) *WorkspaceService {
+	configService := NewConfigService(repoPath, gitFactory.NewRepository(repoPath))
+
+	// Commits are made as the identity set in luna, when there is one
+	gitFactory = git.WithIdentity(gitFactory, configService.Identity)
+
 	return &WorkspaceService{
 		gitFactory:      gitFactory,
-		metadataService: NewMetadataService(repoPath),
+		metadataService: NewMetadataService(repoPath, gitFactory.NewRepository(repoPath)),
+		configService:   configService,
 	}
 }
 
-func (s *WorkspaceService) CreateWorkspace(ctx context.Context, repoPath, name, description string) error {
+// Config returns the luna configuration of the repository.
+func (s *WorkspaceService) Config() (*Config, error) {
+	config, err := s.configService.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	return config, nil
+}
+
+func (s *WorkspaceService) CreateWorkspace(ctx context.Context, repoPath, name, description string) (err error) {
 	repo := s.gitFactory.NewRepository(repoPath)
 
 	isRepo, err := repo.IsRepository(repoPath)
@@ -31,37 +51,97 @@ func (s *WorkspaceService) CreateWorkspace(ctx context.Context, repoPath, name,
 		return fmt.Errorf("not a luna repository")
 	}
 
-	if err := repo.CreateBranch(ctx, name, "luna"); err != nil {
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+	branch := config.WorkspaceBranch(name)
+
+	journal, err := s.beginJournal(ctx, repo, JournalCreate, name, description)
+	if err != nil {
+		return fmt.Errorf("failed to start journal: %w", err)
+	}
+	defer s.abandonJournal(ctx, repoPath, &err)
+
+	if err := s.journalPhase(journal, "branch"); err != nil {
+		return err
+	}
+	if err := repo.CreateBranch(ctx, branch, config.Trunk); err != nil {
 		return fmt.Errorf("failed to create workspace branch: %w", err)
 	}
 
-	if err := repo.SwitchBranch(ctx, name); err != nil {
+	if err := s.journalPhase(journal, "switch"); err != nil {
+		return err
+	}
+	if err := repo.SwitchBranch(ctx, branch); err != nil {
 		return fmt.Errorf("failed to switch to workspace: %w", err)
 	}
 
+	if err := s.journalPhase(journal, "metadata"); err != nil {
+		return err
+	}
 	if err := s.metadataService.CreateWorkspace(name, description); err != nil {
 		return fmt.Errorf("failed to create workspace metadata: %w", err)
 	}
 
-	return nil
+	return s.metadataService.ClearJournal()
 }
 
 func (s *WorkspaceService) CreateStep(ctx context.Context, repoPath, description string) error {
+	return s.createStep(ctx, repoPath, description, nil)
+}
+
+// createStep commits the changes picked by selection, or all of them when it is nil.
+func (s *WorkspaceService) createStep(ctx context.Context, repoPath, description string, selection *StepSelection) (err error) {
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return err
+	}
+
 	repo := s.gitFactory.NewRepository(repoPath)
 	metadata, err := s.metadataService.LoadMetadata()
 	if err != nil {
 		return fmt.Errorf("failed to load metadata: %w", err)
 	}
 
-	currentWorkspace := metadata.CurrentWorkspace
-	if currentWorkspace == "" {
-		return fmt.Errorf("no active workspace - create one with 'luna ws <name> <description>'")
+	currentWorkspace, err := s.requireWorkspace(ctx, repo, metadata)
+	if err != nil {
+		return err
 	}
 
-	if err := repo.StageAll(ctx); err != nil {
+	// A missing identity must fail before the journal starts, not leave a step interrupted
+	if _, _, err := repo.GetSignatures(); err != nil {
+		return err
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+	if err := runHook(ctx, repoPath, config, ConfigHookPreStep, "LUNA_WORKSPACE="+currentWorkspace); err != nil {
+		return err
+	}
+
+	// Rolling back a partial step must bring back the changes it left out too
+	var snapshotHash string
+	if selection != nil {
+		if snapshotHash, err = repo.SnapshotWorktree(ctx); err != nil {
+			return fmt.Errorf("failed to snapshot worktree: %w", err)
+		}
+		if err := s.stageSelection(ctx, repo, selection); err != nil {
+			return err
+		}
+	} else if err := repo.StageAll(ctx); err != nil {
 		return fmt.Errorf("failed to stage changes: %w", err)
 	}
 
+	hasChanges, err := repo.HasStagedChanges(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check for staged changes: %w", err)
+	}
+	if !hasChanges {
+		return fmt.Errorf("nothing to commit - make some changes before starting a new step")
+	}
+
 	nbOfSteps := len(metadata.Workspaces[currentWorkspace].Steps)
 
 	var lastStepDescription string
@@ -71,31 +151,53 @@ func (s *WorkspaceService) CreateStep(ctx context.Context, repoPath, description
 		lastStepDescription = metadata.Workspaces[currentWorkspace].Description
 	}
 
+	journal, err := s.beginJournal(ctx, repo, JournalStep, currentWorkspace, description)
+	if err != nil {
+		return fmt.Errorf("failed to start journal: %w", err)
+	}
+	defer s.abandonJournal(ctx, repoPath, &err)
+	journal.Partial = selection != nil
+	journal.WorktreeHash = snapshotHash
+
+	if err := s.journalPhase(journal, "commit"); err != nil {
+		return err
+	}
 	commitHash, err := repo.Commit(ctx, lastStepDescription)
 	if err != nil {
 		return fmt.Errorf("failed to commit changes: %w", err)
 	}
 
-	if err := s.metadataService.AddStep(description, commitHash); err != nil {
+	if selection == nil {
+		journal.WorktreeHash = commitHash
+	}
+	if err := s.journalPhase(journal, "metadata"); err != nil {
+		return err
+	}
+	if err := s.metadataService.AddStep(currentWorkspace, description, commitHash); err != nil {
 		return fmt.Errorf("failed to add step metadata: %w", err)
 	}
 
-	return nil
+	return s.metadataService.ClearJournal()
 }
 
-func (s *WorkspaceService) FinishWorkspace(ctx context.Context, repoPath string) error {
-	currentWorkspace, err := s.metadataService.GetCurrentWorkspace()
+func (s *WorkspaceService) FinishWorkspace(ctx context.Context, repoPath string, options FinishOptions) (err error) {
+	if err := s.ensureNoConflictInProgress(); err != nil {
+		return err
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
 	if err != nil {
-		return fmt.Errorf("failed to get current workspace: %w", err)
+		return fmt.Errorf("failed to load metadata: %w", err)
 	}
 
-	if currentWorkspace == "" {
-		return fmt.Errorf("no active workspace")
+	config, err := s.Config()
+	if err != nil {
+		return err
 	}
 
-	metadata, err := s.metadataService.LoadMetadata()
+	currentWorkspace, err := s.requireWorkspace(ctx, s.gitFactory.NewRepository(repoPath), metadata)
 	if err != nil {
-		return fmt.Errorf("failed to load metadata: %w", err)
+		return err
 	}
 
 	workspace, exists := metadata.Workspaces[currentWorkspace]
@@ -104,6 +206,37 @@ func (s *WorkspaceService) FinishWorkspace(ctx context.Context, repoPath string)
 	}
 
 	repo := s.gitFactory.NewRepository(repoPath)
+	branch := config.WorkspaceBranch(currentWorkspace)
+
+	originalHash, err := repo.GetBranchHash(ctx, branch)
+	if err != nil {
+		return fmt.Errorf("failed to get workspace tip: %w", err)
+	}
+	worktreeHash := originalHash
+
+	if err := s.ensureTrunkWorktreesClean(ctx, repo); err != nil {
+		return err
+	}
+
+	if _, _, err := repo.GetSignatures(); err != nil {
+		return err
+	}
+	if err := checkLandPolicy(config, repo); err != nil {
+		return err
+	}
+	if err := runHook(ctx, repoPath, config, ConfigHookPreLand, "LUNA_WORKSPACE="+currentWorkspace, "LUNA_TRUNK="+config.Trunk); err != nil {
+		return err
+	}
+
+	journal, err := s.beginJournal(ctx, repo, JournalFinish, currentWorkspace, workspace.Description)
+	if err != nil {
+		return fmt.Errorf("failed to start journal: %w", err)
+	}
+	defer s.abandonJournal(ctx, repoPath, &err)
+
+	if err := s.journalPhase(journal, "commit"); err != nil {
+		return err
+	}
 
 	// Stage and commit any pending changes before squashing
 	if err := repo.StageAll(ctx); err != nil {
@@ -122,34 +255,376 @@ func (s *WorkspaceService) FinishWorkspace(ctx context.Context, repoPath string)
 		if len(workspace.Steps) > 0 {
 			finalStepDescription = workspace.Steps[len(workspace.Steps)-1].Description
 		} else {
-			finalStepDescription = "Final changes"
+			finalStepDescription = config.FinalChangesMessage
 		}
 
 		commitHash, err := repo.Commit(ctx, finalStepDescription)
 		if err != nil {
 			return fmt.Errorf("failed to commit pending changes: %w", err)
 		}
+		worktreeHash = commitHash
+		journal.WorktreeHash = commitHash
 
 		// Add this final commit to the metadata
 		finalStep := Step{
-			Description: "Final workspace changes",
+			Description: config.FinalStepMessage,
 			CommitHash:  commitHash,
 			CreatedAt:   time.Now(),
 		}
 		workspace.Steps = append(workspace.Steps, finalStep)
 		metadata.Workspaces[currentWorkspace] = workspace
+
+		// A finish stopped on conflicts lands later, from what is saved
+		if err := s.metadataService.SaveMetadata(metadata); err != nil {
+			return fmt.Errorf("failed to save final step: %w", err)
+		}
 	}
 
-	if err := repo.SquashAndRebase(ctx, "luna", workspace.Description); err != nil {
+	// Rendered once the final step is recorded, so the message lists it. Cancelling it
+	// rolls the final step back into uncommitted changes
+	message, err := s.squashMessage(ctx, repoPath, repo, config, currentWorkspace, workspace, options)
+	if err != nil {
+		return err
+	}
+	journal.Message = message
+
+	stepCommits, err := repo.ListBranchCommits(ctx, branch, config.Trunk)
+	if err != nil {
+		return fmt.Errorf("failed to list workspace commits: %w", err)
+	}
+
+	journal.StepCommits = stepCommits
+	if err := s.journalPhase(journal, "squash"); err != nil {
+		return err
+	}
+	squashedHash, err := repo.SquashAndRebase(ctx, config.Trunk, message)
+	if err != nil {
+		var conflictErr *git.ConflictError
+		if errors.As(err, &conflictErr) {
+			state := &ConflictState{
+				Operation:       OperationFinish,
+				Workspace:       currentWorkspace,
+				Description:     workspace.Description,
+				Message:         message,
+				TargetBranch:    config.Trunk,
+				TargetHash:      conflictErr.TargetHash,
+				OriginalHash:    originalHash,
+				WorktreeHash:    worktreeHash,
+				ConflictedPaths: conflictErr.Paths,
+				ResolvedPaths:   []string{},
+				StartedAt:       time.Now(),
+			}
+			if err := s.metadataService.SaveConflictState(state); err != nil {
+				return fmt.Errorf("failed to save conflict state: %w", err)
+			}
+			if err := s.metadataService.ClearJournal(); err != nil {
+				return err
+			}
+			return err
+		}
 		return fmt.Errorf("failed to squash and rebase workspace: %w", err)
 	}
 
-	delete(metadata.Workspaces, currentWorkspace)
-	metadata.CurrentWorkspace = ""
+	if err := s.journalPhase(journal, "metadata"); err != nil {
+		return err
+	}
+	if err := s.completeFinish(ctx, repo, metadata, currentWorkspace, squashedHash, stepCommits); err != nil {
+		return err
+	}
+	if err := s.metadataService.ClearJournal(); err != nil {
+		return err
+	}
+
+	s.runPostLandHook(ctx, repoPath, config, currentWorkspace, squashedHash)
+	return nil
+}
+
+// ContinueFinish lands a workspace whose finish stopped on conflicts once every path is resolved.
+func (s *WorkspaceService) ContinueFinish(ctx context.Context, repoPath string) (err error) {
+	state, err := s.loadConflictInProgress(OperationFinish)
+	if err != nil {
+		return err
+	}
+
+	if unresolved := state.UnresolvedPaths(); len(unresolved) > 0 {
+		return fmt.Errorf("%d file(s) still unresolved - mark them with 'luna resolve <path>'", len(unresolved))
+	}
+
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if err := checkLandPolicy(config, repo); err != nil {
+		return err
+	}
+
+	if err := repo.StageAll(ctx); err != nil {
+		return fmt.Errorf("failed to stage resolved files: %w", err)
+	}
+
+	stepCommits, err := repo.ListBranchCommits(ctx, config.WorkspaceBranch(state.Workspace), state.TargetBranch)
+	if err != nil {
+		return fmt.Errorf("failed to list workspace commits: %w", err)
+	}
+
+	// Keep the resolutions around so rolling back does not throw them away
+	resolvedHash, err := repo.CommitResolved(ctx, state.OriginalHash, state.OriginalHash)
+	if err != nil {
+		return fmt.Errorf("failed to snapshot resolved files: %w", err)
+	}
+
+	journal, err := s.beginJournal(ctx, repo, JournalFinish, state.Workspace, state.Description)
+	if err != nil {
+		return fmt.Errorf("failed to start journal: %w", err)
+	}
+	defer s.abandonJournal(ctx, repoPath, &err)
+	journal.Continue = true
+	journal.Message = state.Message
+	journal.WorktreeHash = resolvedHash
+	journal.StepCommits = stepCommits
+
+	// Conflicts saved before squash messages were rendered land with the description
+	message := state.Message
+	if message == "" {
+		message = state.Description
+	}
+
+	if err := s.journalPhase(journal, "squash"); err != nil {
+		return err
+	}
+	squashedHash, err := repo.ContinueSquashAndRebase(ctx, state.TargetBranch, state.TargetHash, message)
+	if err != nil {
+		return fmt.Errorf("failed to land resolved workspace: %w", err)
+	}
+
+	if err := s.journalPhase(journal, "metadata"); err != nil {
+		return err
+	}
+	if err := s.completeFinish(ctx, repo, metadata, state.Workspace, squashedHash, stepCommits); err != nil {
+		return err
+	}
+
+	if err := s.metadataService.ClearConflictState(); err != nil {
+		return fmt.Errorf("failed to clear conflict state: %w", err)
+	}
+	if err := s.metadataService.ClearJournal(); err != nil {
+		return err
+	}
+
+	s.runPostLandHook(ctx, repoPath, config, state.Workspace, squashedHash)
+	return nil
+}
+
+// runPostLandHook runs hooks.post_land once a workspace has landed. Like git's post hooks it
+// cannot undo anything, so its failure is only a warning.
+func (s *WorkspaceService) runPostLandHook(ctx context.Context, repoPath string, config *Config, workspace, commitHash string) {
+	if err := runHook(ctx, repoPath, config, ConfigHookPostLand, "LUNA_WORKSPACE="+workspace, "LUNA_TRUNK="+config.Trunk, "LUNA_COMMIT="+commitHash); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+	}
+}
+
+// AbortFinish restores the workspace branch and worktree as they were before the finish started.
+func (s *WorkspaceService) AbortFinish(ctx context.Context, repoPath string) error {
+	return s.abortConflict(ctx, repoPath, OperationFinish)
+}
+
+func (s *WorkspaceService) abortConflict(ctx context.Context, repoPath, operation string) error {
+	state, err := s.loadConflictInProgress(operation)
+	if err != nil {
+		return err
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if err := repo.RestoreBranch(ctx, config.WorkspaceBranch(state.Workspace), state.OriginalHash, state.WorktreeHash); err != nil {
+		return fmt.Errorf("failed to restore workspace: %w", err)
+	}
+
+	// The step a finish committed the pending changes in is gone with its commit
+	if state.WorktreeHash != state.OriginalHash {
+		if err := s.forgetStep(state.Workspace, state.WorktreeHash); err != nil {
+			return err
+		}
+	}
+
+	if err := s.metadataService.ClearConflictState(); err != nil {
+		return fmt.Errorf("failed to clear conflict state: %w", err)
+	}
+
+	return nil
+}
+
+// forgetStep removes the step of a workspace made by the given commit, if any.
+func (s *WorkspaceService) forgetStep(name, commitHash string) error {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	workspace, exists := metadata.Workspaces[name]
+	if !exists {
+		return nil
+	}
+
+	steps := workspace.Steps[:0]
+	for _, step := range workspace.Steps {
+		if step.CommitHash != commitHash {
+			steps = append(steps, step)
+		}
+	}
+	if len(steps) == len(workspace.Steps) {
+		return nil
+	}
+	workspace.Steps = steps
+	metadata.Workspaces[name] = workspace
 
 	if err := s.metadataService.SaveMetadata(metadata); err != nil {
 		return fmt.Errorf("failed to update metadata: %w", err)
 	}
+	return nil
+}
+
+// ConflictState returns the in-progress conflicted operation, or nil if there is none.
+func (s *WorkspaceService) ConflictState() (*ConflictState, error) {
+	state, err := s.metadataService.LoadConflictState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conflict state: %w", err)
+	}
+	return state, nil
+}
+
+// MarkResolved marks conflicted paths as resolved after checking they no longer hold conflict markers.
+func (s *WorkspaceService) MarkResolved(ctx context.Context, repoPath string, paths []string) error {
+	state, err := s.loadConflictInProgress("")
+	if err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		path = filepath.ToSlash(filepath.Clean(path))
+
+		conflicted := false
+		for _, p := range state.ConflictedPaths {
+			if p == path {
+				conflicted = true
+				break
+			}
+		}
+		if !conflicted {
+			return fmt.Errorf("'%s' is not a conflicted file", path)
+		}
+
+		fullPath := filepath.Join(repoPath, filepath.FromSlash(path))
+		content, err := os.ReadFile(fullPath)
+		if err != nil && !os.IsNotExist(err) {
+			// A file/directory conflict can be resolved with the directory in its place
+			if info, statErr := os.Stat(fullPath); statErr != nil || !info.IsDir() {
+				return fmt.Errorf("failed to read %s: %w", path, err)
+			}
+		}
+		if hasConflictMarkers(content) {
+			return fmt.Errorf("'%s' still contains conflict markers", path)
+		}
+
+		if !state.IsResolved(path) {
+			state.ResolvedPaths = append(state.ResolvedPaths, path)
+		}
+	}
+
+	if err := s.metadataService.SaveConflictState(state); err != nil {
+		return fmt.Errorf("failed to save conflict state: %w", err)
+	}
 
 	return nil
 }
+
+// completeFinish archives the landed workspace under its squashed commit and forgets it.
+// The step chain stays reachable under refs/luna/archive/<workspace>.
+func (s *WorkspaceService) completeFinish(ctx context.Context, repo git.Repository, metadata *LunaMetadata, workspaceName, squashedHash string, stepCommits []string) error {
+	archived := ArchivedWorkspace{
+		Workspace:   metadata.Workspaces[workspaceName],
+		StepCommits: stepCommits,
+		LandedAt:    time.Now(),
+	}
+
+	if len(stepCommits) > 0 {
+		archiveRef := git.ArchiveRefPrefix + workspaceName
+
+		// Workspace names get reused, never overwrite an older archive
+		existing, err := repo.GetReference(ctx, archiveRef)
+		if err != nil {
+			return fmt.Errorf("failed to check archive reference: %w", err)
+		}
+		if existing != "" {
+			archiveRef += "-" + squashedHash[:7]
+		}
+
+		if err := repo.SetReference(ctx, archiveRef, stepCommits[len(stepCommits)-1]); err != nil {
+			return fmt.Errorf("failed to archive workspace steps: %w", err)
+		}
+		archived.ArchiveRef = archiveRef
+	}
+
+	metadata.Archived[squashedHash] = archived
+	delete(metadata.Workspaces, workspaceName)
+	metadata.CurrentWorkspace = ""
+
+	if err := s.metadataService.SaveMetadata(metadata); err != nil {
+		return fmt.Errorf("failed to update metadata: %w", err)
+	}
+
+	return s.configService.RemoveWorkspace(workspaceName)
+}
+
+func (s *WorkspaceService) ensureNoConflictInProgress() error {
+	state, err := s.metadataService.LoadConflictState()
+	if err != nil {
+		return fmt.Errorf("failed to load conflict state: %w", err)
+	}
+	if state == nil {
+		return nil
+	}
+
+	command := "luna ws done"
+	if state.Operation == OperationSync {
+		command = "luna ws sync"
+	}
+	return fmt.Errorf("%s of workspace '%s' stopped on conflicts - run '%s --continue' or '%s --abort'", state.Operation, state.Workspace, command, command)
+}
+
+// loadConflictInProgress returns the conflicted operation, which must match operation unless it is empty.
+func (s *WorkspaceService) loadConflictInProgress(operation string) (*ConflictState, error) {
+	state, err := s.metadataService.LoadConflictState()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load conflict state: %w", err)
+	}
+	if state == nil || (operation != "" && state.Operation != operation) {
+		if operation == "" {
+			return nil, fmt.Errorf("no conflicts in progress")
+		}
+		return nil, fmt.Errorf("no workspace %s in progress", operation)
+	}
+	return state, nil
+}
+
+func hasConflictMarkers(content []byte) bool {
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if bytes.HasPrefix(line, []byte("<<<<<<< ")) || bytes.HasPrefix(line, []byte(">>>>>>> ")) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,202 @@
+package luna
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/okzmo/luna/internal/git"
+)
+
+// CreateWorkspaceInWorktree creates a workspace checked out in its own linked worktree, so it
+// can be worked on while the current worktree stays where it is. An empty dir puts the
+// worktree next to the main one, as <repo>-<name>. It returns the worktree path.
+func (s *WorkspaceService) CreateWorkspaceInWorktree(ctx context.Context, repoPath, name, description, dir string) (path string, err error) {
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	isRepo, err := repo.IsRepository(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to check repository: %w", err)
+	}
+	if !isRepo {
+		return "", fmt.Errorf("not a luna repository")
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return "", err
+	}
+	branch := config.WorkspaceBranch(name)
+
+	dir, err = s.worktreePath(repoPath, name, dir)
+	if err != nil {
+		return "", err
+	}
+
+	journal, err := s.beginJournal(ctx, repo, JournalCreate, name, description)
+	if err != nil {
+		return "", fmt.Errorf("failed to start journal: %w", err)
+	}
+	defer s.abandonJournal(ctx, repoPath, &err)
+
+	journal.Worktree = dir
+	if err := s.journalPhase(journal, "branch"); err != nil {
+		return "", err
+	}
+	if err := repo.CreateBranch(ctx, branch, config.Trunk); err != nil {
+		return "", fmt.Errorf("failed to create workspace branch: %w", err)
+	}
+
+	if err := s.journalPhase(journal, "worktree"); err != nil {
+		return "", err
+	}
+	if err := repo.AddWorktree(ctx, dir, branch); err != nil {
+		return "", fmt.Errorf("failed to create worktree: %w", err)
+	}
+
+	if err := s.journalPhase(journal, "metadata"); err != nil {
+		return "", err
+	}
+	if err := s.metadataService.CreateWorkspace(name, description); err != nil {
+		return "", fmt.Errorf("failed to create workspace metadata: %w", err)
+	}
+
+	return dir, s.metadataService.ClearJournal()
+}
+
+// OpenWorkspace checks out an existing workspace in its own linked worktree, bringing its
+// parked uncommitted changes along. If the workspace is already open in another worktree,
+// that one is returned.
+func (s *WorkspaceService) OpenWorkspace(ctx context.Context, repoPath, name, dir string) (string, error) {
+	metadata, err := s.metadataService.LoadMetadata()
+	if err != nil {
+		return "", fmt.Errorf("failed to load metadata: %w", err)
+	}
+
+	if _, exists := metadata.Workspaces[name]; !exists {
+		return "", fmt.Errorf("workspace '%s' not found", name)
+	}
+
+	config, err := s.Config()
+	if err != nil {
+		return "", err
+	}
+	branch := config.WorkspaceBranch(name)
+
+	repo := s.gitFactory.NewRepository(repoPath)
+
+	if current, err := repo.GetCurrentBranch(ctx); err == nil && current == branch {
+		return "", fmt.Errorf("workspace '%s' is already checked out here", name)
+	}
+
+	open, err := s.openElsewhere(ctx, repo, branch)
+	if err != nil {
+		return "", err
+	}
+	if open != "" {
+		return open, nil
+	}
+
+	dir, err = s.worktreePath(repoPath, name, dir)
+	if err != nil {
+		return "", err
+	}
+
+	if err := repo.AddWorktree(ctx, dir, branch); err != nil {
+		return "", fmt.Errorf("failed to create worktree: %w", err)
+	}
+
+	if _, _, err := s.gitFactory.NewRepository(dir).RestoreWIP(ctx, branch); err != nil {
+		return "", fmt.Errorf("failed to restore uncommitted changes: %w", err)
+	}
+
+	return dir, nil
+}
+
+// worktreePath returns the absolute path for a new worktree of workspace name, defaulting to
+// a sibling of the main worktree.
+func (s *WorkspaceService) worktreePath(repoPath, name, dir string) (string, error) {
+	if dir == "" {
+		_, commonDir, err := git.ResolveGitDirs(repoPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to find git directory: %w", err)
+		}
+		main := filepath.Dir(commonDir)
+		dir = filepath.Join(filepath.Dir(main), fmt.Sprintf("%s-%s", filepath.Base(main), name))
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve worktree path: %w", err)
+	}
+
+	if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+		return "", fmt.Errorf("%s already exists and is not empty", dir)
+	}
+
+	return dir, nil
+}
+
+// openElsewhere returns the path of another worktree that has the branch checked out,
+// "" if there is none.
+func (s *WorkspaceService) openElsewhere(ctx context.Context, repo git.Repository, branch string) (string, error) {
+	worktrees, err := repo.ListWorktrees(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to list worktrees: %w", err)
+	}
+
+	self, err := filepath.Abs(repo.GetPath())
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+
+	for _, worktree := range worktrees {
+		if worktree.Branch == branch && filepath.Clean(worktree.Path) != self {
+			return worktree.Path, nil
+		}
+	}
+
+	return "", nil
+}
+
+// ensureNotOpenElsewhere refuses to touch a workspace another worktree has checked out.
+func (s *WorkspaceService) ensureNotOpenElsewhere(ctx context.Context, repo git.Repository, name string) error {
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	open, err := s.openElsewhere(ctx, repo, config.WorkspaceBranch(name))
+	if err != nil {
+		return err
+	}
+	if open != "" {
+		return fmt.Errorf("workspace '%s' is open in %s - work on it from there", name, open)
+	}
+	return nil
+}
+
+// ensureTrunkWorktreesClean refuses to land while another worktree has uncommitted changes
+// on the trunk, since that worktree is moved along with the branch.
+func (s *WorkspaceService) ensureTrunkWorktreesClean(ctx context.Context, repo git.Repository) error {
+	config, err := s.Config()
+	if err != nil {
+		return err
+	}
+
+	open, err := s.openElsewhere(ctx, repo, config.Trunk)
+	if err != nil || open == "" {
+		return err
+	}
+
+	clean, err := s.gitFactory.NewRepository(open).IsClean(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to check worktree %s: %w", open, err)
+	}
+	if !clean {
+		return fmt.Errorf("%s is checked out with uncommitted changes in %s - commit or discard them first", config.Trunk, open)
+	}
+
+	return nil
+}